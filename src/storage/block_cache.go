@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"container/list"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+)
+
+// Caches the most recently read fixed-size blocks of an underlying io.ReaderAt, so that
+// repeated small reads, such as zip.NewReader seeking between the central directory and
+// the local headers, do not each become a separate request to a remote backend.
+type blockCacheReaderAt struct {
+	reader    io.ReaderAt
+	size      int64
+	blockSize int64
+	maxBlocks int
+	// held while fetching, since concurrent misses would likely fetch the same block
+	mu     sync.Mutex
+	lru    *list.List
+	blocks map[int64]*list.Element
+}
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+func newBlockCacheReaderAt(reader io.ReaderAt, size int64, blockSize int64, maxBlocks int) (*blockCacheReaderAt, error) {
+	if blockSize <= 0 {
+		return nil, errors.Errorf("invalid block size %d", blockSize)
+	}
+	if maxBlocks <= 0 {
+		return nil, errors.Errorf("invalid max blocks %d", maxBlocks)
+	}
+	return &blockCacheReaderAt{
+		reader:    reader,
+		size:      size,
+		blockSize: blockSize,
+		maxBlocks: maxBlocks,
+		lru:       list.New(),
+		blocks:    map[int64]*list.Element{},
+	}, nil
+}
+
+func (c *blockCacheReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for n < len(p) && off < c.size {
+		index := off / c.blockSize
+		block, err := c.block(index)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], block[off-index*c.blockSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (c *blockCacheReaderAt) block(index int64) ([]byte, error) {
+	if element, ok := c.blocks[index]; ok {
+		c.lru.MoveToFront(element)
+		return element.Value.(*cachedBlock).data, nil
+	}
+	offset := index * c.blockSize
+	length := c.blockSize
+	if offset+length > c.size {
+		length = c.size - offset
+	}
+	data := make([]byte, length)
+	if n, err := c.reader.ReadAt(data, offset); n < len(data) {
+		if err == nil || err == io.EOF {
+			// the block lies within size, so the file must have shrunk
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	c.blocks[index] = c.lru.PushFront(&cachedBlock{index: index, data: data})
+	for c.lru.Len() > c.maxBlocks {
+		back := c.lru.Back()
+		c.lru.Remove(back)
+		delete(c.blocks, back.Value.(*cachedBlock).index)
+	}
+	return data, nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+// Counts the reads that reach the underlying reader.
+type countingReaderAt struct {
+	reader io.ReaderAt
+	reads  int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.reader.ReadAt(p, off)
+}
+
+// Returns fewer bytes than requested without an error.
+type shortReaderAt struct{}
+
+func (shortReaderAt) ReadAt(p []byte, _ int64) (int, error) {
+	return len(p) / 2, nil
+}
+
+func TestBlockCacheReadAt(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	tests := []struct {
+		name   string
+		offset int64
+		length int
+		want   string
+		err    error
+	}{
+		{"within block", 1, 2, "12", nil},
+		{"across blocks", 2, 10, "23456789ab", nil},
+		{"whole file", 0, 20, string(data), nil},
+		{"last block", 16, 4, "ghij", nil},
+		{"past end", 18, 4, "ij", io.EOF},
+		{"at end", 20, 1, "", io.EOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache, err := newBlockCacheReaderAt(bytes.NewReader(data), int64(len(data)), 4, 2)
+			assert.NoError(t, err)
+			p := make([]byte, test.length)
+			n, err := cache.ReadAt(p, test.offset)
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.want, string(p[:n]))
+		})
+	}
+}
+
+func TestBlockCacheReusesBlocks(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	reader := &countingReaderAt{reader: bytes.NewReader(data)}
+	cache, err := newBlockCacheReaderAt(reader, int64(len(data)), 4, 2)
+	assert.NoError(t, err)
+	p := make([]byte, 2)
+	for _, offset := range []int64{0, 2, 4, 6, 0} {
+		_, err := cache.ReadAt(p, offset)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, reader.reads)
+	// evicts the block at 4, which was used less recently than the one at 0
+	for _, offset := range []int64{8, 0, 4} {
+		_, err := cache.ReadAt(p, offset)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 4, reader.reads)
+}
+
+func TestBlockCacheShortRead(t *testing.T) {
+	cache, err := newBlockCacheReaderAt(shortReaderAt{}, 20, 4, 2)
+	assert.NoError(t, err)
+	n, err := cache.ReadAt(make([]byte, 8), 0)
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestBlockCacheInvalidSizes(t *testing.T) {
+	tests := []struct {
+		name      string
+		blockSize int64
+		maxBlocks int
+	}{
+		{"zero block size", 0, 1},
+		{"negative block size", -1, 1},
+		{"zero max blocks", 4, 0},
+		{"negative max blocks", 4, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newBlockCacheReaderAt(bytes.NewReader(nil), 0, test.blockSize, test.maxBlocks)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd)
+
+package storage
+
+func freeDiskBytes(path string) (int64, error) {
+	return 0, errDiskSpaceUnsupported
+}
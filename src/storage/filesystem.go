@@ -2,90 +2,282 @@ package storage
 
 import (
 	"SignTools/src/util"
-	"bytes"
-	"github.com/natefinch/atomic"
+	"context"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
 	"io"
-	"io/ioutil"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
-	"sync"
+	"time"
 )
 
 type FSName string
 
 type FileSystem interface {
 	GetString(FSName) (string, error)
+	GetBytes(FSName) ([]byte, error)
 	GetFile(FSName) (ReadonlyFile, error)
+	GetSeekableFile(FSName) (io.ReadSeekCloser, int64, error)
 	SetString(FSName, string) error
 	SetFile(FSName, io.Reader) error
 	RemoveFile(FSName) error
+	RemoveFileIfExists(FSName) error
 	Stat(name FSName) (os.FileInfo, error)
 	MkDir(name FSName) error
 	ReadDir(name FSName) ([]os.DirEntry, error)
+	ListFiles(prefix FSName) ([]FSName, error)
+	ListModifiedSince(prefix FSName, since time.Time) ([]FSName, error)
+	TreeHash(prefix FSName) (string, error)
+	WriteTo(name FSName, w io.Writer, offset int64, length int64) (int64, error)
+	StreamArchive(prefix FSName, format ArchiveFormat, w io.Writer) error
 }
 
 type FileSystemBase struct {
-	mu          sync.RWMutex
+	mu          contextRWMutex
 	resolvePath func(FSName) string
+	// Maps an on-disk path component back to its FSName component, see WithNameSanitizer.
+	decodeName func(string) (string, error)
+	// How temp files are committed over their target, see WithWriteStrategy.
+	writeStrategy WriteStrategy
+	writeFallback bool
+	// Defaults to atomic.ReplaceFile, replaceable for tests.
+	replaceFile func(source string, target string) error
+	// Defaults to os.Rename, replaceable for tests of WriteRemoveRename, SwapFiles and
+	// WithTempDir.
+	rename func(source string, target string) error
+	// Defaults to os.Remove, replaceable for tests of RemoveAll.
+	remove func(path string) error
+	// See WithTempNamer.
+	tempNamer func(FSName) string
+	// See WithIORetries.
+	ioRetries int
+	// See WithMinFreeBytes.
+	minFreeBytes int64
+	// Defaults to freeDiskBytes, replaceable for tests of WithMinFreeBytes.
+	freeBytes func(path string) (int64, error)
+	// See WithMaxReadBytes.
+	maxReadBytes int64
+	// See WithLogger.
+	logger      *zerolog.Logger
+	redactPaths bool
+	// See WithCachePolicy.
+	cachePolicies []cachePolicy
+	// See WithHardlinkCopy.
+	hardlinkCopy bool
+	// Defaults to os.Link, replaceable for tests of WithHardlinkCopy.
+	link func(source string, target string) error
+	// See WithCopyReadBack.
+	copyReadBack bool
+	// See WithCopyBufferSize.
+	copyBufferSize int
+	// See WithTempDir.
+	tempDir       string
+	tempDirAtomic bool
+	tempDirCopy   bool
+	tempDirErr    error
+	// See WithLongNameHashing, nil if disabled.
+	longNames *longNames
+	// See WithIdempotencyTTL.
+	idempotencyTTL time.Duration
+	// Serializes SetFileIdempotent per key, so a key is never processed twice concurrently.
+	idempotencyKeys nameLocks
+}
+
+type FileSystemOption func(*FileSystemBase)
+
+// Creates a FileSystemBase rooted at rootPath. Options are applied in order.
+func NewFileSystemBase(rootPath string, opts ...FileSystemOption) *FileSystemBase {
+	a := &FileSystemBase{resolvePath: func(name FSName) string {
+		return util.SafeJoinFilePaths(rootPath, string(name))
+	}}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.tempDir != "" {
+		a.probeTempDir()
+	}
+	return a
 }
 
 func (a *FileSystemBase) GetString(name FSName) (string, error) {
+	return a.GetStringContext(context.Background(), name)
+}
+
+// Returns the raw, untrimmed contents of the file. Fails with ErrFileTooLarge for files
+// over the limit set by WithMaxReadBytes, which defaults to 64 MiB.
+func (a *FileSystemBase) GetBytes(name FSName) (_ []byte, err error) {
+	defer a.trace("get bytes", name)(&err)
 	a.mu.RLock()
 	defer a.mu.RUnlock()
-	data, err := ioutil.ReadFile(a.resolvePath(name))
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(data)), nil
+	return a.readFileLimited(a.resolvePath(name))
 }
 
 func (a *FileSystemBase) SetString(name FSName, value string) error {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-	reader := bytes.NewReader([]byte(strings.TrimSpace(value)))
-	return atomic.WriteFile(a.resolvePath(name), reader)
+	return a.SetFile(name, strings.NewReader(strings.TrimSpace(value)))
 }
 
 func (a *FileSystemBase) GetFile(name FSName) (ReadonlyFile, error) {
+	return a.GetFileContext(context.Background(), name)
+}
+
+// Returns the opened file along with its size, e.g. for http.ServeContent.
+func (a *FileSystemBase) GetSeekableFile(name FSName) (_ io.ReadSeekCloser, _ int64, err error) {
+	defer a.trace("get seekable file", name)(&err)
 	a.mu.RLock()
 	defer a.mu.RUnlock()
-	return os.Open(a.resolvePath(name))
+	f, err := os.Open(a.resolvePath(name))
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, stat.Size(), nil
 }
 
-func (a *FileSystemBase) SetFile(name FSName, value io.Reader) error {
-	dir, file := filepath.Split(a.resolvePath(name))
-	if dir == "" {
-		dir = "."
-	}
-	f, err := ioutil.TempFile(dir, file)
+// Streams length bytes of the file starting at offset into w, or everything after offset
+// if length is -1, and returns the number of bytes written. The file is closed before
+// returning. Copying from the file directly lets w use sendfile when it is a socket.
+func (a *FileSystemBase) WriteTo(name FSName, w io.Writer, offset int64, length int64) (_ int64, err error) {
+	defer a.trace("write to", name)(&err)
+	a.mu.RLock()
+	f, err := os.Open(a.resolvePath(name))
+	a.mu.RUnlock()
 	if err != nil {
-		return errors.WithMessage(err, "create temp file")
+		return 0, err
 	}
-	defer os.Remove(f.Name())
 	defer f.Close()
-	if _, err := io.Copy(f, value); err != nil {
-		return errors.WithMessage(err, "save file")
-	}
-	if err := f.Sync(); err != nil {
-		return errors.WithMessage(err, "sync changes")
-	}
-	if err := f.Close(); err != nil {
-		return errors.WithMessage(err, "close file")
+	return copyRange(w, f, offset, length, a.copyBufferSizeOrDefault())
+}
+
+// Streams the files under prefix into w as a zip or tar archive, reading one file at a time,
+// so no archive is staged on disk. Entry names are relative to prefix, and hidden and temp
+// files are skipped like in ListFiles.
+func (a *FileSystemBase) StreamArchive(prefix FSName, format ArchiveFormat, w io.Writer) error {
+	return streamArchive(a, prefix, format, w)
+}
+
+// Atomically replaces the file with the contents of value. An empty value creates
+// a real zero-length file, which Stat and Exists report as present.
+func (a *FileSystemBase) SetFile(name FSName, value io.Reader) error {
+	return a.SetFileSized(name, value, -1)
+}
+
+// Like SetFile, but preallocates size bytes before copying to reduce fragmentation.
+// A negative size means unknown. The stored file is as long as value, even if size was wrong.
+func (a *FileSystemBase) SetFileSized(name FSName, value io.Reader, size int64) (err error) {
+	defer a.trace("set file", name)(&err)
+	tempPath, err := a.writeTempFile(name, value, size)
+	if err != nil {
+		return err
 	}
+	defer os.Remove(tempPath)
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	if err := atomic.ReplaceFile(f.Name(), a.resolvePath(name)); err != nil {
+	if err := a.commitTempFile(tempPath, a.resolveWritePath(name)); err != nil {
 		return errors.WithMessage(err, "replace file")
 	}
 	return nil
 }
 
-func (a *FileSystemBase) RemoveFile(name FSName) error {
+// Writes all updates to temp files first, then replaces the targets back-to-back under the
+// write lock. Readers see either the old or the new set, except for the short window
+// between the renames. This is not transactional: if a rename fails, earlier ones stay applied.
+func (a *FileSystemBase) SwapSet(updates map[FSName]io.ReadSeeker) error {
+	tempPaths := map[FSName]string{}
+	defer func() {
+		for _, tempPath := range tempPaths {
+			os.Remove(tempPath)
+		}
+	}()
+	for name, value := range updates {
+		tempPath, err := a.writeTempFile(name, value, -1)
+		if err != nil {
+			return errors.WithMessagef(err, "write %s", name)
+		}
+		tempPaths[name] = tempPath
+	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	return os.Remove(a.resolvePath(name))
+	for name, tempPath := range tempPaths {
+		if err := a.commitTempFile(tempPath, a.resolveWritePath(name)); err != nil {
+			return errors.WithMessagef(err, "replace %s", name)
+		}
+	}
+	return nil
+}
+
+// Copies value to a new temp file next to the target, or in the temp dir, and returns its path.
+// The caller is responsible for removing it.
+func (a *FileSystemBase) writeTempFile(name FSName, value io.Reader, size int64) (string, error) {
+	if a.tempDirErr != nil {
+		return "", a.tempDirErr
+	}
+	targetPath := a.resolveWritePath(name)
+	dir, file := a.tempFileDir(targetPath), filepath.Base(targetPath)
+	if size > 0 {
+		if err := a.checkFreeSpace(dir, size); err != nil {
+			return "", err
+		}
+	}
+	f, err := a.createTempFile(name, dir, file)
+	if err != nil {
+		return "", errors.WithMessage(err, "create temp file")
+	}
+	defer f.Close()
+	if size > 0 {
+		// only an optimization, so ignore failures
+		_ = preallocate(f, size)
+	}
+	var writer io.Writer = &retryWriter{f, a}
+	if a.minFreeBytes > 0 {
+		// sized writes were checked up front, so only check once they exceed their size
+		unchecked := size
+		if unchecked < 0 {
+			unchecked = 0
+		}
+		writer = &freeSpaceWriter{Writer: writer, a: a, dir: dir, remaining: unchecked + freeSpaceCheckBytes}
+	}
+	written, err := copyBufferedSize(writer, &retryReader{value, a}, a.copyBufferSizeOrDefault())
+	if err != nil {
+		os.Remove(f.Name())
+		return "", errors.WithMessage(err, "save file")
+	}
+	if size > 0 && written != size {
+		if err := f.Truncate(written); err != nil {
+			os.Remove(f.Name())
+			return "", errors.WithMessage(err, "truncate file")
+		}
+	}
+	if err := f.Sync(); err != nil {
+		os.Remove(f.Name())
+		return "", errors.WithMessage(err, "sync changes")
+	}
+	// the temp file already takes up its space, so the check also covers the bytes written
+	// since the last check of freeSpaceWriter
+	if err := a.checkFreeSpace(dir, 0); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", errors.WithMessage(err, "close file")
+	}
+	return f.Name(), nil
+}
+
+func (a *FileSystemBase) RemoveFile(name FSName) error {
+	return a.RemoveFileContext(context.Background(), name)
+}
+
+// Like RemoveFile, but succeeds if the file does not exist.
+func (a *FileSystemBase) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(a, name)
 }
 
 func (a *FileSystemBase) Stat(name FSName) (os.FileInfo, error) {
@@ -94,16 +286,187 @@ func (a *FileSystemBase) Stat(name FSName) (os.FileInfo, error) {
 	return os.Stat(a.resolvePath(name))
 }
 
-func (a *FileSystemBase) MkDir(name FSName) error {
+func (a *FileSystemBase) Exists(name FSName) (bool, error) {
+	if _, err := a.Stat(name); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (a *FileSystemBase) MkDir(name FSName) (err error) {
+	defer a.trace("mkdir", name)(&err)
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	return os.MkdirAll(a.resolvePath(name), 0700)
+	return os.MkdirAll(a.resolveWritePath(name), 0700)
 }
 
+// Like ListFiles, entries are named by their FSName component rather than the on-disk name.
 func (a *FileSystemBase) ReadDir(name FSName) ([]os.DirEntry, error) {
 	dirs, err := os.ReadDir(a.resolvePath(name))
 	if err != nil {
 		return nil, err
 	}
-	return util.RemoveHiddenDirs(dirs), nil
+	dirs = util.RemoveHiddenDirs(dirs)
+	if a.decodeName == nil {
+		return dirs, nil
+	}
+	for i, dir := range dirs {
+		decoded, err := a.decodeName(dir.Name())
+		if err != nil {
+			return nil, errors.WithMessagef(err, "decode %s", dir.Name())
+		}
+		dirs[i] = &decodedDirEntry{DirEntry: dir, name: decoded}
+	}
+	return dirs, nil
+}
+
+type decodedDirEntry struct {
+	fs.DirEntry
+	name string
+}
+
+func (e *decodedDirEntry) Name() string {
+	return e.name
+}
+
+func (e *decodedDirEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return &decodedFileInfo{FileInfo: info, name: e.name}, nil
+}
+
+type decodedFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i *decodedFileInfo) Name() string {
+	return i.name
+}
+
+// Recursively lists all files under prefix, ignoring hidden files and directories.
+func (a *FileSystemBase) ListFiles(prefix FSName) (_ []FSName, err error) {
+	defer a.trace("list files", prefix)(&err)
+	var names []FSName
+	err = a.walkFiles(prefix, func(name FSName, _ fs.DirEntry) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Like ListFiles, but only returns files modified after since.
+func (a *FileSystemBase) ListModifiedSince(prefix FSName, since time.Time) (_ []FSName, err error) {
+	defer a.trace("list modified since", prefix)(&err)
+	var names []FSName
+	err = a.walkFiles(prefix, func(name FSName, d fs.DirEntry) error {
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			// removed during the walk
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.ModTime().After(since) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (a *FileSystemBase) TreeHash(prefix FSName) (string, error) {
+	return treeHash(a, prefix)
+}
+
+// Calls fn for each non-hidden file under prefix, in lexical order of the on-disk paths.
+func (a *FileSystemBase) walkFiles(prefix FSName, fn func(FSName, fs.DirEntry) error) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.walkFilesLocked(prefix, fn)
+}
+
+// Like walkFiles, but the caller must hold the lock.
+func (a *FileSystemBase) walkFilesLocked(prefix FSName, fn func(FSName, fs.DirEntry) error) error {
+	root := a.resolvePath(prefix)
+	return filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		name, err := a.decodePath(relPath)
+		if err != nil {
+			return errors.WithMessagef(err, "decode %s", relPath)
+		}
+		return fn(FSName(path.Join(string(prefix), name)), d)
+	})
+}
+
+func (a *FileSystemBase) decodePath(relPath string) (string, error) {
+	components := strings.Split(filepath.ToSlash(relPath), "/")
+	if a.decodeName != nil {
+		for i, component := range components {
+			decoded, err := a.decodeName(component)
+			if err != nil {
+				return "", err
+			}
+			components[i] = decoded
+		}
+	}
+	return path.Join(components...), nil
+}
+
+// Like GetString, but returns def if the file does not exist.
+func (a *FileSystemBase) GetStringOrDefault(name FSName, def string) (string, error) {
+	value, err := a.GetString(name)
+	if isNotFound(err) {
+		return def, nil
+	} else if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// Like GetFile, but returns def as a ReadonlyFile if the file does not exist.
+func (a *FileSystemBase) GetFileOrReader(name FSName, def io.ReadSeeker) (ReadonlyFile, error) {
+	file, err := a.GetFile(name)
+	if isNotFound(err) {
+		return newReaderFile(path.Base(string(name)), def, time.Time{}), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func removeFileIfExists(fs FileSystem, name FSName) error {
+	if err := fs.RemoveFile(name); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	return err != nil && (os.IsNotExist(err) || errors.Is(err, ErrNotFound) || errors.Is(err, fs.ErrNotExist))
 }
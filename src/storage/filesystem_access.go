@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const accessIndexName = FSName(".access.json")
+
+type accessRecord struct {
+	LastAccess time.Time `json:"last_access"`
+	Reads      uint64    `json:"reads"`
+}
+
+// Records when each file was last accessed and how often it was read, so the least
+// recently used files can be evicted when space runs low. The statistics are kept
+// in memory and persisted to a hidden index file by Flush and Evict.
+type AccessTrackingFileSystem struct {
+	FileSystem
+	mu        sync.Mutex
+	records   map[FSName]*accessRecord
+	freeBytes func() (int64, error)
+	pinned    func(FSName) bool
+}
+
+// freeBytes reports the currently available space, see FileSystemBase.FreeBytes.
+// Names for which pinned returns true are never evicted, pinned may be nil.
+func NewAccessTrackingFileSystem(fs FileSystem, freeBytes func() (int64, error), pinned func(FSName) bool) (*AccessTrackingFileSystem, error) {
+	t := &AccessTrackingFileSystem{
+		FileSystem: fs,
+		records:    map[FSName]*accessRecord{},
+		freeBytes:  freeBytes,
+		pinned:     pinned,
+	}
+	data, err := fs.GetString(accessIndexName)
+	if isNotFound(err) {
+		return t, nil
+	} else if err != nil {
+		return nil, errors.WithMessage(err, "get access index")
+	}
+	if err := json.Unmarshal([]byte(data), &t.records); err != nil {
+		return nil, errors.WithMessage(err, "unmarshal access index")
+	}
+	return t, nil
+}
+
+// Returns the bytes available on the filesystem holding the store.
+func (a *FileSystemBase) FreeBytes() (int64, error) {
+	return freeDiskBytes(a.resolvePath(""))
+}
+
+func (t *AccessTrackingFileSystem) touch(name FSName, read bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	record, ok := t.records[name]
+	if !ok {
+		record = &accessRecord{}
+		t.records[name] = record
+	}
+	record.LastAccess = time.Now()
+	if read {
+		record.Reads++
+	}
+}
+
+// Returns the last access time and read count of name, if it was ever accessed.
+func (t *AccessTrackingFileSystem) GetAccess(name FSName) (time.Time, uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	record, ok := t.records[name]
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	return record.LastAccess, record.Reads, true
+}
+
+func (t *AccessTrackingFileSystem) GetString(name FSName) (string, error) {
+	value, err := t.FileSystem.GetString(name)
+	if err == nil {
+		t.touch(name, true)
+	}
+	return value, err
+}
+
+func (t *AccessTrackingFileSystem) GetBytes(name FSName) ([]byte, error) {
+	data, err := t.FileSystem.GetBytes(name)
+	if err == nil {
+		t.touch(name, true)
+	}
+	return data, err
+}
+
+func (t *AccessTrackingFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	file, err := t.FileSystem.GetFile(name)
+	if err == nil {
+		t.touch(name, true)
+	}
+	return file, err
+}
+
+func (t *AccessTrackingFileSystem) GetSeekableFile(name FSName) (io.ReadSeekCloser, int64, error) {
+	file, size, err := t.FileSystem.GetSeekableFile(name)
+	if err == nil {
+		t.touch(name, true)
+	}
+	return file, size, err
+}
+
+func (t *AccessTrackingFileSystem) WriteTo(name FSName, w io.Writer, offset int64, length int64) (int64, error) {
+	written, err := t.FileSystem.WriteTo(name, w, offset, length)
+	if err == nil {
+		t.touch(name, true)
+	}
+	return written, err
+}
+
+func (t *AccessTrackingFileSystem) SetString(name FSName, value string) error {
+	if err := t.FileSystem.SetString(name, value); err != nil {
+		return err
+	}
+	t.touch(name, false)
+	return nil
+}
+
+func (t *AccessTrackingFileSystem) SetFile(name FSName, value io.Reader) error {
+	if err := t.FileSystem.SetFile(name, value); err != nil {
+		return err
+	}
+	t.touch(name, false)
+	return nil
+}
+
+func (t *AccessTrackingFileSystem) RemoveFile(name FSName) error {
+	if err := t.FileSystem.RemoveFile(name); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	delete(t.records, name)
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *AccessTrackingFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(t, name)
+}
+
+// Persists the access statistics.
+func (t *AccessTrackingFileSystem) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.flush()
+}
+
+func (t *AccessTrackingFileSystem) flush() error {
+	data, err := json.Marshal(t.records)
+	if err != nil {
+		return errors.WithMessage(err, "marshal access index")
+	}
+	if err := t.FileSystem.SetFile(accessIndexName, bytes.NewReader(data)); err != nil {
+		return errors.WithMessage(err, "save access index")
+	}
+	return nil
+}
+
+// Removes the least recently accessed files until at least targetFreeBytes are available.
+// Files never accessed through this wrapper are ordered by their mod time instead.
+// Returns the evicted names.
+func (t *AccessTrackingFileSystem) Evict(targetFreeBytes int64) ([]FSName, error) {
+	free, err := t.freeBytes()
+	if err != nil {
+		return nil, errors.WithMessage(err, "get free bytes")
+	}
+	if free >= targetFreeBytes {
+		return nil, nil
+	}
+	names, err := t.FileSystem.ListFiles("")
+	if err != nil {
+		return nil, errors.WithMessage(err, "list files")
+	}
+	lastAccess := map[FSName]time.Time{}
+	var candidates []FSName
+	for _, name := range names {
+		if t.pinned != nil && t.pinned(name) {
+			continue
+		}
+		if accessed, _, ok := t.GetAccess(name); ok {
+			lastAccess[name] = accessed
+		} else if stat, err := t.FileSystem.Stat(name); err == nil {
+			lastAccess[name] = stat.ModTime()
+		} else if isNotFound(err) {
+			continue
+		} else {
+			return nil, errors.WithMessagef(err, "stat %s", name)
+		}
+		candidates = append(candidates, name)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return lastAccess[candidates[i]].Before(lastAccess[candidates[j]])
+	})
+	var evicted []FSName
+	for _, name := range candidates {
+		if free >= targetFreeBytes {
+			break
+		}
+		if err := t.RemoveFileIfExists(name); err != nil {
+			return evicted, errors.WithMessagef(err, "evict %s", name)
+		}
+		evicted = append(evicted, name)
+		if free, err = t.freeBytes(); err != nil {
+			return evicted, errors.WithMessage(err, "get free bytes")
+		}
+	}
+	if err := t.Flush(); err != nil {
+		return evicted, err
+	}
+	return evicted, nil
+}
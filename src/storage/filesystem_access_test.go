@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Pretends the store has capacity bytes, so eviction can be tested without filling a disk.
+func fakeFreeBytes(fs *FileSystemBase, capacity int64) func() (int64, error) {
+	return func() (int64, error) {
+		names, err := fs.ListFiles("")
+		if err != nil {
+			return 0, err
+		}
+		free := capacity
+		for _, name := range names {
+			stat, err := fs.Stat(name)
+			if err != nil {
+				return 0, err
+			}
+			free -= stat.Size()
+		}
+		return free, nil
+	}
+}
+
+func TestAccessTrackingRecords(t *testing.T) {
+	base := NewFileSystemBase(t.TempDir())
+	a, err := NewAccessTrackingFileSystem(base, fakeFreeBytes(base, 100), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, a.SetString("file", "value"))
+	_, reads, ok := a.GetAccess("file")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0), reads)
+
+	for i := 0; i < 3; i++ {
+		_, err := a.GetString("file")
+		assert.NoError(t, err)
+	}
+	_, err = a.GetString("missing")
+	assert.True(t, isNotFound(err))
+	_, reads, _ = a.GetAccess("file")
+	assert.Equal(t, uint64(3), reads)
+	_, _, ok = a.GetAccess("missing")
+	assert.False(t, ok)
+
+	// the statistics survive a restart once flushed
+	assert.NoError(t, a.Flush())
+	restarted, err := NewAccessTrackingFileSystem(base, fakeFreeBytes(base, 100), nil)
+	assert.NoError(t, err)
+	_, reads, ok = restarted.GetAccess("file")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), reads)
+
+	assert.NoError(t, restarted.RemoveFile("file"))
+	_, _, ok = restarted.GetAccess("file")
+	assert.False(t, ok)
+}
+
+func TestAccessTrackingEvict(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  int64
+		pinned  func(FSName) bool
+		evicted []FSName
+	}{
+		{"enough space", 70, nil, nil},
+		{"least recently used", 80, nil, []FSName{"b"}},
+		{"several", 90, nil, []FSName{"b", "c"}},
+		{"pinned", 80, func(name FSName) bool { return name == "b" }, []FSName{"c"}},
+		{"everything", 1000, nil, []FSName{"b", "c", "a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := NewFileSystemBase(t.TempDir())
+			a, err := NewAccessTrackingFileSystem(base, fakeFreeBytes(base, 100), test.pinned)
+			assert.NoError(t, err)
+			// 30 bytes in use, so 70 are free
+			for _, name := range []FSName{"a", "b", "c"} {
+				assert.NoError(t, a.SetString(name, "0123456789"))
+			}
+			// a is now the most recently used, then c, then b
+			for _, name := range []FSName{"b", "c", "a"} {
+				_, err := a.GetString(name)
+				assert.NoError(t, err)
+			}
+			evicted, err := a.Evict(test.target)
+			assert.NoError(t, err)
+			assert.Equal(t, test.evicted, evicted)
+			for _, name := range evicted {
+				exists, err := base.Exists(name)
+				assert.NoError(t, err)
+				assert.False(t, exists)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// The format written by StreamArchive.
+type ArchiveFormat int
+
+const (
+	ArchiveZip ArchiveFormat = iota
+	ArchiveTar
+)
+
+// Implements FileSystem.StreamArchive on top of ListFiles and GetFile.
+func streamArchive(fs FileSystem, prefix FSName, format ArchiveFormat, w io.Writer) error {
+	names, err := fs.ListFiles(prefix)
+	if err != nil {
+		return errors.WithMessage(err, "list files")
+	}
+	var archive archiveWriter
+	switch format {
+	case ArchiveZip:
+		archive = &zipArchiveWriter{zip.NewWriter(w)}
+	case ArchiveTar:
+		archive = &tarArchiveWriter{tar.NewWriter(w)}
+	default:
+		return errors.Errorf("unknown archive format %d", format)
+	}
+	for _, name := range names {
+		entryName := strings.TrimPrefix(strings.TrimPrefix(string(name), string(prefix)), "/")
+		if err := addArchiveEntry(fs, archive, name, entryName); err != nil {
+			return errors.WithMessagef(err, "add %s", name)
+		}
+	}
+	return archive.Close()
+}
+
+func addArchiveEntry(fs FileSystem, archive archiveWriter, name FSName, entryName string) error {
+	file, err := fs.GetFile(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// stat the opened file, so the size matches what is read even if name is replaced meanwhile
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	entry, err := archive.Create(entryName, stat.Size(), stat.ModTime())
+	if err != nil {
+		return err
+	}
+	_, err = copyBuffered(entry, file)
+	return err
+}
+
+type archiveWriter interface {
+	Create(name string, size int64, modTime time.Time) (io.Writer, error)
+	Close() error
+}
+
+type zipArchiveWriter struct {
+	*zip.Writer
+}
+
+func (z *zipArchiveWriter) Create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	return z.CreateHeader(&zip.FileHeader{
+		Name:               name,
+		Method:             zip.Deflate,
+		Modified:           modTime,
+		UncompressedSize64: uint64(size),
+	})
+}
+
+type tarArchiveWriter struct {
+	*tar.Writer
+}
+
+func (t *tarArchiveWriter) Create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	err := t.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0600,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.Writer, nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type archiveEntry struct {
+	size    int64
+	modTime int64
+	value   string
+}
+
+func readZipArchive(t *testing.T, data []byte) map[string]archiveEntry {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	entries := map[string]archiveEntry{}
+	for _, file := range reader.File {
+		f, err := file.Open()
+		assert.NoError(t, err)
+		value, err := io.ReadAll(f)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+		entries[file.Name] = archiveEntry{int64(file.UncompressedSize64), file.Modified.Unix(), string(value)}
+	}
+	return entries
+}
+
+func readTarArchive(t *testing.T, data []byte) map[string]archiveEntry {
+	reader := tar.NewReader(bytes.NewReader(data))
+	entries := map[string]archiveEntry{}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return entries
+		}
+		assert.NoError(t, err)
+		value, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		entries[header.Name] = archiveEntry{header.Size, header.ModTime.Unix(), string(value)}
+	}
+}
+
+func TestStreamArchive(t *testing.T) {
+	tests := []struct {
+		name   string
+		format ArchiveFormat
+		read   func(t *testing.T, data []byte) map[string]archiveEntry
+	}{
+		{"zip", ArchiveZip, readZipArchive},
+		{"tar", ArchiveTar, readTarArchive},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			fs := NewFileSystemBase(root)
+			createCompactTree(t, root, []string{
+				"dir/a",
+				"dir/sub/b",
+				"dir/.hidden",
+				"dir/.a" + tempFileInfix + "1",
+				"other",
+			})
+			assert.NoError(t, fs.SetString("dir/sub/b", "longer value"))
+			modTimes := map[string]time.Time{
+				"a":     time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC),
+				"sub/b": time.Date(2021, 5, 6, 7, 8, 10, 0, time.UTC),
+			}
+			for name, modTime := range modTimes {
+				assert.NoError(t, os.Chtimes(filepath.Join(root, "dir", name), modTime, modTime))
+			}
+
+			var archive bytes.Buffer
+			assert.NoError(t, fs.StreamArchive("dir", test.format, &archive))
+			// names are relative to the prefix, and hidden and temp files are left out
+			assert.Equal(t, map[string]archiveEntry{
+				"a":     {5, modTimes["a"].Unix(), "value"},
+				"sub/b": {12, modTimes["sub/b"].Unix(), "longer value"},
+			}, test.read(t, archive.Bytes()))
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Returned by batch operations that continue past individual failures.
+// Use errors.Is or errors.As to inspect the underlying causes.
+type BatchError struct {
+	Succeeded int
+	Failures  []*BatchFailure
+}
+
+type BatchFailure struct {
+	Name FSName
+	Err  error
+}
+
+func (f *BatchFailure) Error() string {
+	return fmt.Sprintf("%s: %s", f.Name, f.Err)
+}
+
+func (f *BatchFailure) Unwrap() error {
+	return f.Err
+}
+
+func (e *BatchError) Error() string {
+	var failures []string
+	for _, failure := range e.Failures {
+		failures = append(failures, failure.Error())
+	}
+	return fmt.Sprintf("%d failed, %d succeeded: %s", len(e.Failures), e.Succeeded, strings.Join(failures, "; "))
+}
+
+func (e *BatchError) Unwrap() []error {
+	var errs []error
+	for _, failure := range e.Failures {
+		errs = append(errs, failure)
+	}
+	return errs
+}
+
+// Removes prefix and everything under it, including hidden files, but never the root of the
+// store itself. Individual failures do not stop the removal, instead they are returned
+// together as a *BatchError. Dirs that are left because a file in them failed are not
+// reported again. Returns the number of removed files.
+func (a *FileSystemBase) RemoveAll(prefix FSName) (_ int, err error) {
+	defer a.trace("remove all", prefix)(&err)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	remove := a.remove
+	if remove == nil {
+		remove = os.Remove
+	}
+	root := a.resolvePath(prefix)
+	storeRoot := a.resolvePath("")
+	batchErr := &BatchError{}
+	var failedPaths []string
+	fail := func(filePath string, err error) {
+		failedPaths = append(failedPaths, filePath)
+		batchErr.Failures = append(batchErr.Failures, &BatchFailure{Name: a.relativeName(prefix, root, filePath), Err: err})
+	}
+	var dirs []string
+	err = filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if filePath == root {
+				return err
+			}
+			fail(filePath, err)
+			return nil
+		}
+		if d.IsDir() {
+			if filePath != storeRoot {
+				dirs = append(dirs, filePath)
+			}
+			return nil
+		}
+		if err := remove(filePath); err != nil {
+			fail(filePath, err)
+			return nil
+		}
+		batchErr.Succeeded++
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	// deepest first, so parents are empty by the time they are removed
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if err := remove(dir); err != nil && !containsPath(failedPaths, dir) {
+			fail(dir, err)
+		}
+	}
+	if len(batchErr.Failures) > 0 {
+		return batchErr.Succeeded, batchErr
+	}
+	return batchErr.Succeeded, nil
+}
+
+// Whether any of paths is dir or below it.
+func containsPath(paths []string, dir string) bool {
+	for _, filePath := range paths {
+		if filePath == dir || strings.HasPrefix(filePath, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Best-effort conversion of a resolved path under root back to its FSName.
+func (a *FileSystemBase) relativeName(prefix FSName, root string, filePath string) FSName {
+	relPath, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return FSName(filePath)
+	}
+	name, err := a.decodePath(relPath)
+	if err != nil {
+		return FSName(filepath.Join(string(prefix), relPath))
+	}
+	return FSName(filepath.ToSlash(filepath.Join(string(prefix), name)))
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestRemoveAll(t *testing.T) {
+	errRemove := errors.New("remove failed")
+	tests := []struct {
+		name         string
+		prefix       FSName
+		failing      []string
+		removed      int
+		wantFailures []FSName
+		want         []string
+	}{
+		{"prefix", "a", nil, 3, nil, []string{"other"}},
+		{"nested prefix", "a/b", nil, 1, nil, []string{"a/", "a/.hidden", "a/c/", "a/x", "other"}},
+		{"store root", "", nil, 4, nil, nil},
+		{"missing", "missing", nil, 0, nil, []string{"a/", "a/.hidden", "a/b/", "a/b/y", "a/c/", "a/x", "other"}},
+		{"file fails", "a", []string{"a/b/y"}, 2, []FSName{"a/b/y"}, []string{"a/", "a/b/", "a/b/y", "other"}},
+		{"file and dir fail", "a", []string{"a/b/y", "a/c"}, 2, []FSName{"a/b/y", "a/c"}, []string{"a/", "a/b/", "a/b/y", "a/c/", "other"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			fs := NewFileSystemBase(root)
+			createCompactTree(t, root, []string{"a/x", "a/.hidden", "a/b/y", "a/c/", "other"})
+			fs.remove = func(filePath string) error {
+				for _, failing := range test.failing {
+					if filePath == filepath.Join(root, failing) {
+						return &os.PathError{Op: "remove", Path: filePath, Err: errRemove}
+					}
+				}
+				return os.Remove(filePath)
+			}
+			removed, err := fs.RemoveAll(test.prefix)
+			assert.Equal(t, test.removed, removed)
+			if test.wantFailures == nil {
+				assert.NoError(t, err)
+			} else {
+				var batchErr *BatchError
+				assert.ErrorAs(t, err, &batchErr)
+				assert.Equal(t, test.removed, batchErr.Succeeded)
+				var names []FSName
+				for _, failure := range batchErr.Failures {
+					names = append(names, failure.Name)
+				}
+				assert.Equal(t, test.wantFailures, names)
+				// the causes are reachable through Unwrap() []error
+				assert.ErrorIs(t, err, errRemove)
+				assert.NotErrorIs(t, err, syscall.ENOTEMPTY)
+			}
+			assert.Equal(t, test.want, listCompactTree(t, root))
+
+			// the store stays usable
+			assert.NoError(t, fs.SetString("new", "value"))
+		})
+	}
+}
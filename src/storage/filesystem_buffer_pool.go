@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Buffers that grew beyond this are dropped instead of pooled, so one large file does not
+// pin its memory for good.
+const maxPooledBufferSize = 64 << 10
+
+const (
+	defaultCopyBufferSize = 32 << 10
+	maxCopyBufferSize     = 1 << 20
+)
+
+var stringBufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// Copy buffers shared by all writes and streaming reads in the package, pooled by size so
+// FileSystems with different buffer sizes do not mix them up. Concurrent large uploads reuse
+// a few buffers instead of allocating one per copy. A pool holds at most one buffer per
+// concurrent copy, and sync.Pool releases idle buffers over the next garbage collections,
+// so memory use follows the load and does not stay at its peak.
+var copyBufferPools sync.Map // of int to *sync.Pool
+
+func copyBufferPool(size int) *sync.Pool {
+	if pool, ok := copyBufferPools.Load(size); ok {
+		return pool.(*sync.Pool)
+	}
+	pool, _ := copyBufferPools.LoadOrStore(size, &sync.Pool{
+		New: func() any {
+			buffer := make([]byte, size)
+			return &buffer
+		},
+	})
+	return pool.(*sync.Pool)
+}
+
+// Sets the size of the buffers used to copy file contents, 32 KiB by default. Larger buffers
+// mean fewer syscalls for large files at the cost of memory per concurrent copy. The size is
+// capped at 1 MiB.
+func WithCopyBufferSize(size int) FileSystemOption {
+	return func(a *FileSystemBase) {
+		a.copyBufferSize = size
+	}
+}
+
+func (a *FileSystemBase) copyBufferSizeOrDefault() int {
+	if a.copyBufferSize <= 0 {
+		return defaultCopyBufferSize
+	} else if a.copyBufferSize > maxCopyBufferSize {
+		return maxCopyBufferSize
+	}
+	return a.copyBufferSize
+}
+
+// Like io.Copy, but with a pooled buffer of the default size. Like io.Copy, it still uses
+// io.WriterTo or io.ReaderFrom if src or dst implement them, e.g. for sendfile.
+func copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	return copyBufferedSize(dst, src, defaultCopyBufferSize)
+}
+
+// Like copyBuffered, but with a pooled buffer of size bytes.
+func copyBufferedSize(dst io.Writer, src io.Reader, size int) (int64, error) {
+	pool := copyBufferPool(size)
+	buffer := pool.Get().(*[]byte)
+	defer pool.Put(buffer)
+	return io.CopyBuffer(dst, src, *buffer)
+}
+
+// Like io.CopyN, but with a pooled buffer of the default size.
+func copyBufferedN(dst io.Writer, src io.Reader, n int64) (int64, error) {
+	return copyBufferedNSize(dst, src, n, defaultCopyBufferSize)
+}
+
+// Like copyBufferedN, but with a pooled buffer of size bytes.
+func copyBufferedNSize(dst io.Writer, src io.Reader, n int64, size int) (int64, error) {
+	written, err := copyBufferedSize(dst, io.LimitReader(src, n), size)
+	if written == n {
+		return n, nil
+	}
+	if written < n && err == nil {
+		err = io.EOF
+	}
+	return written, err
+}
+
+// Reads the trimmed file contents through a pooled buffer, so reading many small files
+// only allocates the returned string. Larger files are read without the pool.
+func (a *FileSystemBase) readString(filePath string) (string, error) {
+	var f *os.File
+	err := a.retryTransient(func() error {
+		var err error
+		f, err = os.Open(filePath)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var buffer *bytes.Buffer
+	// files that would outgrow a pooled buffer get one of their own size, rather than growing
+	// a pooled one only to drop it
+	if stat, err := f.Stat(); err == nil && stat.Size() > maxPooledBufferSize {
+		buffer = bytes.NewBuffer(make([]byte, 0, stat.Size()+bytes.MinRead))
+	} else {
+		buffer = stringBufferPool.Get().(*bytes.Buffer)
+		buffer.Reset()
+		defer func() {
+			if buffer.Cap() <= maxPooledBufferSize {
+				stringBufferPool.Put(buffer)
+			}
+		}()
+	}
+	if _, err := buffer.ReadFrom(&retryReader{f, a}); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buffer.String()), nil
+}
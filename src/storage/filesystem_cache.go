@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HTTP caching metadata for a file, see GetFileWithCacheInfo.
+type CacheInfo struct {
+	// Quoted, ready for the ETag header.
+	ETag    string
+	ModTime time.Time
+	MaxAge  time.Duration
+	// Set for files that never change once written, e.g. content-addressed names.
+	Immutable bool
+}
+
+// Returns the value for the Cache-Control header.
+func (c CacheInfo) CacheControl() string {
+	if c.MaxAge <= 0 {
+		return "no-cache"
+	}
+	value := fmt.Sprintf("max-age=%d", int64(c.MaxAge/time.Second))
+	if c.Immutable {
+		value += ", immutable"
+	}
+	return value
+}
+
+type cachePolicy struct {
+	prefix    FSName
+	maxAge    time.Duration
+	immutable bool
+}
+
+// Sets the max-age that GetFileWithCacheInfo reports for names under prefix. When several
+// prefixes match, the longest wins. Names without a policy must be revalidated on every use.
+func WithCachePolicy(prefix FSName, maxAge time.Duration, immutable bool) FileSystemOption {
+	return func(a *FileSystemBase) {
+		a.cachePolicies = append(a.cachePolicies, cachePolicy{prefix: prefix, maxAge: maxAge, immutable: immutable})
+	}
+}
+
+func (a *FileSystemBase) cachePolicy(name FSName) cachePolicy {
+	var match cachePolicy
+	for _, policy := range a.cachePolicies {
+		if isUnderPrefix(name, policy.prefix) && len(policy.prefix) >= len(match.prefix) {
+			match = policy
+		}
+	}
+	return match
+}
+
+// Reports whether name is prefix or inside it, so "app" matches "app/file" but not "apple".
+// An empty prefix matches every name.
+func isUnderPrefix(name FSName, prefix FSName) bool {
+	trimmed := strings.TrimSuffix(string(prefix), "/")
+	return trimmed == "" || string(name) == trimmed || strings.HasPrefix(string(name), trimmed+"/")
+}
+
+// Like GetFile, but also returns the caching metadata of the opened file, so a handler can
+// set the Cache-Control, ETag and Last-Modified headers. The ETag is derived from the size
+// and mod time, so it changes whenever the file is replaced.
+func (a *FileSystemBase) GetFileWithCacheInfo(name FSName) (ReadonlyFile, CacheInfo, error) {
+	file, err := a.GetFile(name)
+	if err != nil {
+		return nil, CacheInfo{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, CacheInfo{}, err
+	}
+	policy := a.cachePolicy(name)
+	return file, CacheInfo{
+		ETag:      fmt.Sprintf(`"%x-%x"`, stat.Size(), stat.ModTime().UnixNano()),
+		ModTime:   stat.ModTime(),
+		MaxAge:    policy.maxAge,
+		Immutable: policy.immutable,
+	}, nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCachePolicy(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir(),
+		WithCachePolicy("", time.Minute, false),
+		WithCachePolicy("app", time.Hour, false),
+		WithCachePolicy("app/static/", 24*time.Hour, true),
+	)
+	tests := []struct {
+		name         string
+		file         FSName
+		cacheControl string
+	}{
+		{"default", "file", "max-age=60"},
+		{"exact prefix", "app", "max-age=3600"},
+		{"under prefix", "app/file", "max-age=3600"},
+		{"sibling with same start", "apple/file", "max-age=60"},
+		{"longest prefix", "app/static/file.js", "max-age=86400, immutable"},
+		{"sibling of longest prefix", "app/staticfile", "max-age=3600"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := fs.cachePolicy(test.file)
+			info := CacheInfo{MaxAge: policy.maxAge, Immutable: policy.immutable}
+			assert.Equal(t, test.cacheControl, info.CacheControl())
+		})
+	}
+}
+
+func TestCacheInfoWithoutPolicy(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, fs.SetString("file", "value"))
+	file, info, err := fs.GetFileWithCacheInfo("file")
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.Equal(t, "no-cache", info.CacheControl())
+	assert.NotEmpty(t, info.ETag)
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"hash"
+	"io"
+	"os"
+	"path"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Stores the SHA-256 digest of every written file in a hidden sidecar next to it,
+// so silent corruption can be detected later with Verify or Scan.
+type ChecksumFileSystem struct {
+	FileSystem
+	// held across writing a file and its sidecar, so concurrent writes of a name never
+	// leave the digest of one next to the content of another
+	names nameLocks
+}
+
+func NewChecksumFileSystem(fs FileSystem) *ChecksumFileSystem {
+	return &ChecksumFileSystem{FileSystem: fs}
+}
+
+// Returns the name of a hidden metadata file stored next to name, e.g. "dir/.file.sha256".
+func sidecarName(name FSName, ext string) FSName {
+	dir, file := path.Split(string(name))
+	return FSName(path.Join(dir, "."+file+"."+ext))
+}
+
+func checksumName(name FSName) FSName {
+	return sidecarName(name, "sha256")
+}
+
+func (c *ChecksumFileSystem) SetString(name FSName, value string) error {
+	defer c.names.lock(string(name))()
+	if err := c.FileSystem.SetString(name, value); err != nil {
+		return err
+	}
+	// the underlying FileSystem may normalize the value, so hash what was actually stored
+	digest, err := hashFromFileSystem(c.FileSystem, name, sha256.New())
+	if err != nil {
+		return errors.WithMessage(err, "hash file")
+	}
+	return c.FileSystem.SetString(checksumName(name), digest)
+}
+
+func (c *ChecksumFileSystem) SetFile(name FSName, value io.Reader) error {
+	defer c.names.lock(string(name))()
+	hash := sha256.New()
+	if err := c.FileSystem.SetFile(name, io.TeeReader(value, hash)); err != nil {
+		return err
+	}
+	return c.FileSystem.SetString(checksumName(name), hex.EncodeToString(hash.Sum(nil)))
+}
+
+func (c *ChecksumFileSystem) RemoveFile(name FSName) error {
+	defer c.names.lock(string(name))()
+	if err := c.FileSystem.RemoveFile(name); err != nil {
+		return err
+	}
+	if err := c.FileSystem.RemoveFileIfExists(checksumName(name)); err != nil {
+		return errors.WithMessage(err, "remove checksum")
+	}
+	return nil
+}
+
+func (c *ChecksumFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(c, name)
+}
+
+// Returns the stored digest of name, or an error satisfying isNotFound if there is none.
+func (c *ChecksumFileSystem) GetChecksum(name FSName) (string, error) {
+	return c.FileSystem.GetString(checksumName(name))
+}
+
+// Re-hashes name and returns ErrChecksumMismatch if it no longer matches the stored digest.
+func (c *ChecksumFileSystem) Verify(name FSName) error {
+	defer c.names.rlock(string(name))()
+	expected, err := c.GetChecksum(name)
+	if err != nil {
+		return errors.WithMessage(err, "get checksum")
+	}
+	actual, err := hashFromFileSystem(c.FileSystem, name, sha256.New())
+	if err != nil {
+		return errors.WithMessage(err, "hash file")
+	}
+	if actual != expected {
+		return errors.WithMessagef(ErrChecksumMismatch, "%s", name)
+	}
+	return nil
+}
+
+// Verifies all files under prefix that have a stored digest and returns the corrupted ones.
+// If repair is not nil, it is called for each corrupted file. Repair failures do not stop
+// the scan and are returned together as a *BatchError.
+func (c *ChecksumFileSystem) Scan(prefix FSName, repair func(FSName) error) ([]FSName, error) {
+	names, err := c.FileSystem.ListFiles(prefix)
+	if err != nil {
+		return nil, errors.WithMessage(err, "list files")
+	}
+	var corrupted []FSName
+	batchErr := &BatchError{}
+	for _, name := range names {
+		err := c.Verify(name)
+		if err == nil || isNotFound(err) {
+			continue
+		} else if !errors.Is(err, ErrChecksumMismatch) {
+			return corrupted, err
+		}
+		corrupted = append(corrupted, name)
+		if repair == nil {
+			continue
+		}
+		if err := repair(name); err != nil {
+			batchErr.Failures = append(batchErr.Failures, &BatchFailure{Name: name, Err: err})
+		} else {
+			batchErr.Succeeded++
+		}
+	}
+	if len(batchErr.Failures) > 0 {
+		return corrupted, batchErr
+	}
+	return corrupted, nil
+}
+
+// Implemented by FileSystems that can hash a file without handing it out, see HashFile.
+type fileHasher interface {
+	HashFile(name FSName, h hash.Hash) (string, error)
+}
+
+// Streams name through h and returns the hex-encoded digest, with fs.HashFile if available.
+func hashFromFileSystem(fs FileSystem, name FSName, h hash.Hash) (string, error) {
+	if hasher, ok := fs.(fileHasher); ok {
+		return hasher.HashFile(name, h)
+	}
+	file, err := fs.GetFile(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return hashReader(file, h)
+}
+
+// Streams the file through h under the read lock and returns the hex-encoded digest,
+// e.g. with sha1.New for Apple tooling or crc32.NewIEEE for integrations.
+func (a *FileSystemBase) HashFile(name FSName, h hash.Hash) (_ string, err error) {
+	defer a.trace("hash file", name)(&err)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	var f *os.File
+	err = a.retryTransient(func() error {
+		var err error
+		f, err = os.Open(a.resolvePath(name))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(&retryReader{f, a}, h)
+}
+
+func hashReader(reader io.Reader, h hash.Hash) (string, error) {
+	if _, err := copyBuffered(h, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
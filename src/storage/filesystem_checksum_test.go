@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"hash"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sha256Hex(value string) string {
+	digest := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(digest[:])
+}
+
+func TestChecksumWrites(t *testing.T) {
+	tests := []struct {
+		name   string
+		write  func(c *ChecksumFileSystem) error
+		stored string
+	}{
+		{"set string", func(c *ChecksumFileSystem) error {
+			// the base trims values, and the digest must match what was stored
+			return c.SetString("file", " value\n")
+		}, "value"},
+		{"set file", func(c *ChecksumFileSystem) error {
+			return c.SetFile("file", strings.NewReader(" value\n"))
+		}, " value\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewChecksumFileSystem(NewFileSystemBase(t.TempDir()))
+			assert.NoError(t, test.write(c))
+			checksum, err := c.GetChecksum("file")
+			assert.NoError(t, err)
+			assert.Equal(t, sha256Hex(test.stored), checksum)
+			assert.NoError(t, c.Verify("file"))
+			// sidecars are hidden
+			names, err := c.ListFiles("")
+			assert.NoError(t, err)
+			assert.Equal(t, []FSName{"file"}, names)
+		})
+	}
+}
+
+func TestChecksumVerify(t *testing.T) {
+	base := NewFileSystemBase(t.TempDir())
+	c := NewChecksumFileSystem(base)
+	assert.NoError(t, c.SetString("file", "value"))
+	assert.NoError(t, base.SetString("file", "corrupt"))
+	assert.ErrorIs(t, c.Verify("file"), ErrChecksumMismatch)
+
+	assert.NoError(t, base.SetString("unchecked", "value"))
+	assert.True(t, isNotFound(c.Verify("unchecked")))
+}
+
+// Calls beforeSet before each SetString.
+type setHookFileSystem struct {
+	FileSystem
+	beforeSet func(name FSName)
+}
+
+func (h *setHookFileSystem) SetString(name FSName, value string) error {
+	if h.beforeSet != nil {
+		h.beforeSet(name)
+	}
+	return h.FileSystem.SetString(name, value)
+}
+
+func TestChecksumConcurrentWrites(t *testing.T) {
+	hook := &setHookFileSystem{FileSystem: NewFileSystemBase(t.TempDir())}
+	c := NewChecksumFileSystem(hook)
+	written := make(chan error, 1)
+	hook.beforeSet = func(name FSName) {
+		if name != checksumName("file") {
+			return
+		}
+		hook.beforeSet = nil
+		// a write that replaces the file and its digest between writing the file and its
+		// digest, unless it waits for the first write
+		go func() {
+			written <- c.SetFile("file", strings.NewReader("second"))
+		}()
+		select {
+		case err := <-written:
+			written <- err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	assert.NoError(t, c.SetFile("file", strings.NewReader("first")))
+	assert.NoError(t, <-written)
+	value, err := c.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "second", value)
+	assert.NoError(t, c.Verify("file"))
+}
+
+func TestChecksumRemoveFile(t *testing.T) {
+	base := NewFileSystemBase(t.TempDir())
+	c := NewChecksumFileSystem(base)
+	assert.NoError(t, c.SetString("file", "value"))
+	assert.NoError(t, c.RemoveFile("file"))
+	exists, err := base.Exists(checksumName("file"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.True(t, isNotFound(c.RemoveFile("file")))
+	assert.NoError(t, c.RemoveFileIfExists("file"))
+}
+
+func TestChecksumScan(t *testing.T) {
+	tests := []struct {
+		name      string
+		repair    func(c *ChecksumFileSystem) func(FSName) error
+		failed    int
+		succeeded int
+	}{
+		{"no repair", func(*ChecksumFileSystem) func(FSName) error {
+			return nil
+		}, 0, 0},
+		{"repair", func(c *ChecksumFileSystem) func(FSName) error {
+			return func(name FSName) error {
+				return c.SetString(name, "repaired")
+			}
+		}, 0, 2},
+		{"failed repair", func(*ChecksumFileSystem) func(FSName) error {
+			return func(FSName) error {
+				return errors.New("no backup")
+			}
+		}, 2, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := NewFileSystemBase(t.TempDir())
+			c := NewChecksumFileSystem(base)
+			assert.NoError(t, base.MkDir("dir"))
+			for _, name := range []FSName{"a", "dir/b", "dir/c"} {
+				assert.NoError(t, c.SetString(name, "value"))
+			}
+			assert.NoError(t, base.SetString("unchecked", "value"))
+			assert.NoError(t, base.SetString("a", "corrupt"))
+			assert.NoError(t, base.SetString("dir/c", "corrupt"))
+
+			corrupted, err := c.Scan("", test.repair(c))
+			assert.Equal(t, []FSName{"a", "dir/c"}, corrupted)
+			if test.failed > 0 {
+				var batchErr *BatchError
+				assert.ErrorAs(t, err, &batchErr)
+				assert.Len(t, batchErr.Failures, test.failed)
+				return
+			}
+			assert.NoError(t, err)
+			if test.succeeded > 0 {
+				corrupted, err := c.Scan("", nil)
+				assert.NoError(t, err)
+				assert.Empty(t, corrupted)
+			}
+		})
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, fs.SetString("file", "value"))
+	tests := []struct {
+		name string
+		hash func() hash.Hash
+	}{
+		{"sha256", sha256.New},
+		{"sha1", sha1.New},
+		{"crc32", func() hash.Hash { return crc32.NewIEEE() }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := test.hash()
+			expected.Write([]byte("value"))
+			digest, err := fs.HashFile("file", test.hash())
+			assert.NoError(t, err)
+			assert.Equal(t, hex.EncodeToString(expected.Sum(nil)), digest)
+			// FileSystems without HashFile are hashed through GetFile
+			for _, fileSystem := range []FileSystem{fs, NewChecksumFileSystem(fs)} {
+				digest, err := hashFromFileSystem(fileSystem, "file", test.hash())
+				assert.NoError(t, err)
+				assert.Equal(t, hex.EncodeToString(expected.Sum(nil)), digest)
+			}
+		})
+	}
+	_, err := fs.HashFile("missing", sha256.New())
+	assert.True(t, isNotFound(err))
+	_, err = hashFromFileSystem(NewChecksumFileSystem(fs), "missing", sha256.New())
+	assert.True(t, isNotFound(err))
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrNoWork = errors.New("no work")
+
+// Claims the first pending file under prefix by moving it under toPrefix, and returns its new
+// name and contents. Since the move is a rename, each file is claimed exactly once, even by
+// workers in other processes. Returns ErrNoWork if there are no pending files.
+func (a *FileSystemBase) ClaimOne(prefix FSName, toPrefix FSName) (_ FSName, _ ReadonlyFile, err error) {
+	defer a.trace("claim one", prefix)(&err)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var pending []FSName
+	err = a.walkFilesLocked(prefix, func(name FSName, _ fs.DirEntry) error {
+		pending = append(pending, name)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return "", nil, ErrNoWork
+	} else if err != nil {
+		return "", nil, errors.WithMessage(err, "list pending")
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i] < pending[j]
+	})
+	for _, name := range pending {
+		relName := strings.TrimPrefix(strings.TrimPrefix(string(name), string(prefix)), "/")
+		claimedName := FSName(path.Join(string(toPrefix), relName))
+		claimedPath := a.resolveWritePath(claimedName)
+		if err := os.MkdirAll(filepath.Dir(claimedPath), 0700); err != nil {
+			return "", nil, errors.WithMessage(err, "make claimed dir")
+		}
+		if err := os.Rename(a.resolvePath(name), claimedPath); os.IsNotExist(err) {
+			// claimed by another process in the meantime
+			continue
+		} else if err != nil {
+			return "", nil, errors.WithMessagef(err, "claim %s", name)
+		}
+		a.forgetLongName(name)
+		file, err := os.Open(claimedPath)
+		if err != nil {
+			return "", nil, errors.WithMessagef(err, "open claimed %s", claimedName)
+		}
+		return claimedName, file, nil
+	}
+	return "", nil, ErrNoWork
+}
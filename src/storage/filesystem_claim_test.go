@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestClaimOne(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending []FSName
+		source  FSName
+		claimed FSName
+	}{
+		{"first in order", []FSName{"pending/b", "pending/a"}, "pending/a", "claimed/a"},
+		{"nested", []FSName{"pending/dir/a"}, "pending/dir/a", "claimed/dir/a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := NewFileSystemBase(t.TempDir())
+			assert.NoError(t, fs.MkDir("pending/dir"))
+			for _, name := range test.pending {
+				assert.NoError(t, fs.SetString(name, string(name)))
+			}
+			claimed, file, err := fs.ClaimOne("pending", "claimed")
+			assert.NoError(t, err)
+			defer file.Close()
+			assert.Equal(t, test.claimed, claimed)
+			data, err := io.ReadAll(file)
+			assert.NoError(t, err)
+			assert.Equal(t, string(test.source), string(data))
+			exists, err := fs.Exists(test.source)
+			assert.NoError(t, err)
+			assert.False(t, exists)
+		})
+	}
+}
+
+func TestClaimOneNoWork(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	_, _, err := fs.ClaimOne("pending", "claimed")
+	assert.ErrorIs(t, err, ErrNoWork)
+	assert.NoError(t, fs.MkDir("pending"))
+	_, _, err = fs.ClaimOne("pending", "claimed")
+	assert.ErrorIs(t, err, ErrNoWork)
+}
+
+func TestClaimOneConcurrent(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, fs.MkDir("pending"))
+	for _, name := range []FSName{"pending/a", "pending/b", "pending/c"} {
+		assert.NoError(t, fs.SetString(name, "work"))
+	}
+	var mu sync.Mutex
+	claimed := map[FSName]int{}
+	noWork := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name, file, err := fs.ClaimOne("pending", "claimed")
+			mu.Lock()
+			defer mu.Unlock()
+			if err == ErrNoWork {
+				noWork++
+				return
+			}
+			assert.NoError(t, err)
+			file.Close()
+			claimed[name]++
+		}()
+	}
+	wg.Wait()
+	// every item is claimed exactly once
+	assert.Equal(t, map[FSName]int{"claimed/a": 1, "claimed/b": 1, "claimed/c": 1}, claimed)
+	assert.Equal(t, 5, noWork)
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Temp files older than this are left over from interrupted writes, since even huge uploads
+// finish well within it. Empty dirs younger than this are kept, since a write may be about
+// to commit into a dir that was just made for it.
+const staleTempFileAge = 24 * time.Hour
+
+// Removes the empty dirs left behind by deletes, e.g. in a sharded layout, and the stale
+// temp files of interrupted writes, also from the temp dir if one is set. Hidden dirs, dirs
+// modified within staleTempFileAge and the root are kept. The write lock is only held while
+// removing each dir, and dirs that gained files since the walk are skipped, so the store
+// stays usable. Returns the number of removed dirs.
+func (a *FileSystemBase) Compact() (removedDirs int, err error) {
+	defer a.trace("compact", "")(&err)
+	root := a.resolvePath("")
+	a.mu.RLock()
+	dirs, tempFiles, err := a.compactCandidates(root)
+	if err == nil && a.tempDir != "" {
+		var moreTempFiles []string
+		_, moreTempFiles, err = a.compactCandidates(a.tempDir)
+		tempFiles = append(tempFiles, moreTempFiles...)
+	}
+	a.mu.RUnlock()
+	if err != nil {
+		return 0, errors.WithMessage(err, "walk files")
+	}
+	for _, tempFile := range tempFiles {
+		if err := os.Remove(tempFile); err != nil && !os.IsNotExist(err) {
+			return 0, errors.WithMessage(err, "remove temp file")
+		}
+	}
+	// the walk lists parents first, so go backwards to empty children before their parents
+	for i := len(dirs) - 1; i >= 0; i-- {
+		removed, err := a.removeEmptyDir(dirs[i])
+		if err != nil {
+			return removedDirs, errors.WithMessagef(err, "remove %s", dirs[i])
+		}
+		if removed {
+			removedDirs++
+		}
+	}
+	return removedDirs, nil
+}
+
+// Must be called with the read lock held.
+func (a *FileSystemBase) compactCandidates(root string) (dirs []string, tempFiles []string, err error) {
+	staleBefore := time.Now().Add(-staleTempFileAge)
+	err = filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == root {
+			return nil
+		}
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			info, err := d.Info()
+			if isNotFound(err) {
+				return filepath.SkipDir
+			} else if err != nil {
+				return err
+			}
+			if info.ModTime().Before(staleBefore) {
+				dirs = append(dirs, filePath)
+			}
+			return nil
+		}
+		if !isTempFileName(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if isNotFound(err) {
+			// committed or removed since listing the directory
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.ModTime().Before(staleBefore) {
+			tempFiles = append(tempFiles, filePath)
+		}
+		return nil
+	})
+	return dirs, tempFiles, err
+}
+
+func (a *FileSystemBase) removeEmptyDir(dir string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	empty, err := isEmptyDir(dir)
+	if err != nil || !empty {
+		return false, err
+	}
+	if err := os.Remove(dir); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		// temp files are created without the lock, so a write may have just started
+		if empty, emptyErr := isEmptyDir(dir); emptyErr == nil && !empty {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Creates each name under root, as a dir if it ends with a slash. Files containing "stale"
+// and dirs not containing "fresh" get a modification time before staleTempFileAge.
+func createCompactTree(t *testing.T, root string, names []string) {
+	staleTime := time.Now().Add(-2 * staleTempFileAge)
+	for _, name := range names {
+		filePath := filepath.Join(root, name)
+		if strings.HasSuffix(name, "/") {
+			assert.NoError(t, os.MkdirAll(filePath, 0700))
+			continue
+		}
+		writeTestFile(t, filePath, "value")
+		if strings.Contains(name, "stale") {
+			assert.NoError(t, os.Chtimes(filePath, staleTime, staleTime))
+		}
+	}
+	// after creating the files, since they update the mod time of their dirs
+	err := filepath.WalkDir(root, func(filePath string, d os.DirEntry, err error) error {
+		if err != nil || filePath == root || !d.IsDir() {
+			return err
+		}
+		if strings.Contains(strings.TrimPrefix(filePath, root), "fresh") {
+			return nil
+		}
+		return os.Chtimes(filePath, staleTime, staleTime)
+	})
+	assert.NoError(t, err)
+}
+
+// Returns the slash-separated paths under root, with a trailing slash for dirs.
+func listCompactTree(t *testing.T, root string) []string {
+	var names []string
+	err := filepath.WalkDir(root, func(filePath string, d os.DirEntry, err error) error {
+		if err != nil || filePath == root {
+			return err
+		}
+		relPath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relPath)
+		if d.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+		return nil
+	})
+	assert.NoError(t, err)
+	sort.Strings(names)
+	return names
+}
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		name    string
+		tree    []string
+		want    []string
+		removed int
+	}{
+		{"empty root", nil, nil, 0},
+		{"empty dirs", []string{"a/", "b/c/d/"}, nil, 4},
+		{"dirs with files", []string{"a/file", "b/c/", "b/file"}, []string{"a/", "a/file", "b/", "b/file"}, 1},
+		{"hidden dirs", []string{".hidden/", ".hidden/empty/", "a/.hidden/"}, []string{".hidden/", ".hidden/empty/", "a/", "a/.hidden/"}, 0},
+		{"stale temp files", []string{
+			".stale" + tempFileInfix + "1",
+			"a/.stale" + tempFileInfix + "2",
+			"b/.fresh" + tempFileInfix + "3",
+		}, []string{"b/", "b/.fresh" + tempFileInfix + "3"}, 1},
+		{"stale regular files", []string{"a/stale", ".stale"}, []string{".stale", "a/", "a/stale"}, 0},
+		{"fresh dirs", []string{"fresh/", "a/fresh/", "b/c/"}, []string{"a/", "a/fresh/", "fresh/"}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			fs := NewFileSystemBase(root)
+			createCompactTree(t, root, test.tree)
+			removed, err := fs.Compact()
+			assert.NoError(t, err)
+			assert.Equal(t, test.removed, removed)
+			assert.Equal(t, test.want, listCompactTree(t, root))
+
+			// compacting again is a no-op
+			removed, err = fs.Compact()
+			assert.NoError(t, err)
+			assert.Equal(t, 0, removed)
+		})
+	}
+}
+
+func TestCompactTempDir(t *testing.T) {
+	root := t.TempDir()
+	tempDir := t.TempDir()
+	fs := NewFileSystemBase(root, WithTempDir(tempDir, false))
+	createCompactTree(t, tempDir, []string{
+		".stale" + tempFileInfix + "1",
+		".fresh" + tempFileInfix + "2",
+		"empty/",
+	})
+	createCompactTree(t, root, []string{"empty/"})
+	removed, err := fs.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Empty(t, listCompactTree(t, root))
+	// only temp files are removed from the temp dir
+	assert.Equal(t, []string{".fresh" + tempFileInfix + "2", "empty/"}, listCompactTree(t, tempDir))
+}
+
+func TestCompactKeepsStoreUsable(t *testing.T) {
+	root := t.TempDir()
+	fs := NewFileSystemBase(root)
+	createCompactTree(t, root, []string{"a/b/"})
+	removed, err := fs.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	// writes recreate the removed dirs
+	assert.NoError(t, fs.MkDir("a/b"))
+	assert.NoError(t, fs.SetString("a/b/file", "value"))
+	value, err := fs.GetString("a/b/file")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func TestCompactKeepsNewDirs(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir(), WithTempDir(t.TempDir(), false))
+	assert.NoError(t, fs.MkDir("a/b"))
+	// a compaction between making the dir and writing into it
+	removed, err := fs.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+	assert.NoError(t, fs.SetString("a/b/file", "value"))
+	value, err := fs.GetString("a/b/file")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// Like GetString, but returns ctx.Err() if ctx is done before the lock is acquired.
+func (a *FileSystemBase) GetStringContext(ctx context.Context, name FSName) (_ string, err error) {
+	defer a.trace("get string", name)(&err)
+	if err := a.mu.RLockContext(ctx); err != nil {
+		return "", err
+	}
+	defer a.mu.RUnlock()
+	return a.readString(a.resolvePath(name))
+}
+
+// Like GetFile, but returns ctx.Err() if ctx is done before the lock is acquired.
+func (a *FileSystemBase) GetFileContext(ctx context.Context, name FSName) (_ ReadonlyFile, err error) {
+	defer a.trace("get file", name)(&err)
+	if err := a.mu.RLockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	var f *os.File
+	err = a.retryTransient(func() error {
+		var err error
+		f, err = os.Open(a.resolvePath(name))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Like SetString, but gives up when ctx is done, see SetFileContext.
+func (a *FileSystemBase) SetStringContext(ctx context.Context, name FSName, value string) error {
+	return a.SetFileContext(ctx, name, strings.NewReader(strings.TrimSpace(value)))
+}
+
+// Like SetFile, but stops copying and returns ctx.Err() when ctx is done, including
+// while waiting for the lock. The file is left untouched in that case.
+func (a *FileSystemBase) SetFileContext(ctx context.Context, name FSName, value io.Reader) (err error) {
+	defer a.trace("set file", name)(&err)
+	tempPath, err := a.writeTempFile(name, &contextReader{ctx, value}, -1)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+	if err := a.mu.LockContext(ctx); err != nil {
+		return err
+	}
+	defer a.mu.Unlock()
+	if err := a.commitTempFile(tempPath, a.resolveWritePath(name)); err != nil {
+		return errors.WithMessage(err, "replace file")
+	}
+	return nil
+}
+
+// Like RemoveFile, but returns ctx.Err() if ctx is done before the lock is acquired.
+func (a *FileSystemBase) RemoveFileContext(ctx context.Context, name FSName) (err error) {
+	defer a.trace("remove file", name)(&err)
+	if err := a.mu.LockContext(ctx); err != nil {
+		return err
+	}
+	defer a.mu.Unlock()
+	if err := os.Remove(a.resolvePath(name)); err != nil {
+		return err
+	}
+	a.forgetLongName(name)
+	return nil
+}
+
+type contextReader struct {
+	ctx context.Context
+	io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
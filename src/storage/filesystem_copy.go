@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Makes CopyFile hardlink the source instead of copying its bytes when the destination
+// is also a local FileSystemBase, falling back to copying if linking fails, e.g. across
+// devices. Writing either name later is still safe, since a write replaces the inode
+// with a new file rather than modifying the shared one.
+func WithHardlinkCopy() FileSystemOption {
+	return func(a *FileSystemBase) {
+		a.hardlinkCopy = true
+	}
+}
+
+type localFileSystem interface {
+	localBase() *FileSystemBase
+}
+
+func (a *FileSystemBase) localBase() *FileSystemBase {
+	return a
+}
+
+// Streams src into dst on dstFS, which may be this FileSystemBase itself.
+func (a *FileSystemBase) CopyFile(src FSName, dstFS FileSystem, dst FSName) error {
+	if local, ok := dstFS.(localFileSystem); ok && a.hardlinkCopy {
+		err := a.linkFile(src, local.localBase(), dst)
+		if err == nil || isNotFound(err) {
+			return err
+		}
+		a.warn(err, "hardlink failed, falling back to copy")
+	}
+	file, err := a.GetFile(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return dstFS.SetFile(dst, file)
+}
+
+// Makes CopyFileVerified re-read the destination after copying to confirm it matches, at
+// the cost of reading every copied file back.
+func WithCopyReadBack() FileSystemOption {
+	return func(a *FileSystemBase) {
+		a.copyReadBack = true
+	}
+}
+
+// Like CopyFile, but hashes the content while copying and returns its hex-encoded SHA-256
+// digest. With WithCopyReadBack, dst is also re-read to confirm it matches. On mismatch,
+// dst is removed and ErrChecksumMismatch is returned.
+func (a *FileSystemBase) CopyFileVerified(src FSName, dstFS FileSystem, dst FSName) (string, error) {
+	file, err := a.GetFile(src)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if err := dstFS.SetFile(dst, io.TeeReader(file, hash)); err != nil {
+		return "", err
+	}
+	expected := hex.EncodeToString(hash.Sum(nil))
+	if !a.copyReadBack {
+		return expected, nil
+	}
+	actual, err := hashFromFileSystem(dstFS, dst, sha256.New())
+	if err != nil {
+		return "", errors.WithMessage(err, "hash destination")
+	}
+	if actual != expected {
+		if err := dstFS.RemoveFileIfExists(dst); err != nil {
+			return "", errors.WithMessage(err, "remove corrupt destination")
+		}
+		return "", errors.WithMessagef(ErrChecksumMismatch, "copy %s to %s", src, dst)
+	}
+	return expected, nil
+}
+
+// Links src to a temp name next to dst, then commits it like any other write.
+func (a *FileSystemBase) linkFile(src FSName, dstBase *FileSystemBase, dst FSName) error {
+	dir, file := filepath.Split(dstBase.resolveWritePath(dst))
+	if dir == "" {
+		dir = "."
+	}
+	// reserve a unique temp name, which os.Link requires to not exist
+	f, err := dstBase.createTempFile(dst, dir, file)
+	if err != nil {
+		return errors.WithMessage(err, "create temp file")
+	}
+	tempPath := f.Name()
+	f.Close()
+	if err := os.Remove(tempPath); err != nil {
+		return errors.WithMessage(err, "remove temp file")
+	}
+	link := a.link
+	if link == nil {
+		link = os.Link
+	}
+	a.mu.RLock()
+	err = link(a.resolvePath(src), tempPath)
+	a.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+	dstBase.mu.Lock()
+	defer dstBase.mu.Unlock()
+	if err := dstBase.commitTempFile(tempPath, dstBase.resolveWritePath(dst)); err != nil {
+		return errors.WithMessage(err, "replace file")
+	}
+	return nil
+}
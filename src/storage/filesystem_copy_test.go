@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Stores a corrupted copy of everything written to it.
+type corruptingFileSystem struct {
+	FileSystem
+}
+
+func (c *corruptingFileSystem) SetFile(name FSName, value io.Reader) error {
+	return c.FileSystem.SetFile(name, io.MultiReader(value, strings.NewReader("corrupt")))
+}
+
+func TestCopyFileVerified(t *testing.T) {
+	digest := sha256.Sum256([]byte("value"))
+	tests := []struct {
+		name     string
+		readBack bool
+		corrupt  bool
+		err      error
+		exists   bool
+	}{
+		{"copy", false, false, nil, true},
+		{"copy with read back", true, false, nil, true},
+		{"corrupt without read back", false, true, nil, true},
+		{"corrupt with read back", true, true, ErrChecksumMismatch, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var options []FileSystemOption
+			if test.readBack {
+				options = append(options, WithCopyReadBack())
+			}
+			src := NewFileSystemBase(t.TempDir(), options...)
+			assert.NoError(t, src.SetString("file", "value"))
+			dstBase := NewFileSystemBase(t.TempDir())
+			var dst FileSystem = dstBase
+			if test.corrupt {
+				dst = &corruptingFileSystem{dstBase}
+			}
+			checksum, err := src.CopyFileVerified("file", dst, "copy")
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, hex.EncodeToString(digest[:]), checksum)
+			}
+			exists, err := dstBase.Exists("copy")
+			assert.NoError(t, err)
+			assert.Equal(t, test.exists, exists)
+		})
+	}
+}
+
+func TestCopyFileVerifiedMissing(t *testing.T) {
+	src := NewFileSystemBase(t.TempDir(), WithCopyReadBack())
+	_, err := src.CopyFileVerified("missing", NewFileSystemBase(t.TempDir()), "copy")
+	assert.True(t, isNotFound(err))
+}
+
+func TestHardlinkCopy(t *testing.T) {
+	errLink := errors.New("link failed")
+	tests := []struct {
+		name   string
+		link   func(source string, target string) error
+		linked bool
+	}{
+		{"link", nil, true},
+		{"fallback", func(string, string) error { return errLink }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			src := NewFileSystemBase(root, WithHardlinkCopy())
+			src.link = test.link
+			dstRoot := t.TempDir()
+			dst := NewFileSystemBase(dstRoot)
+			assert.NoError(t, src.SetString("file", "value"))
+			assert.NoError(t, dst.SetString("copy", "old"))
+			assert.NoError(t, src.CopyFile("file", dst, "copy"))
+
+			value, err := dst.GetString("copy")
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+			srcStat, err := os.Stat(src.resolvePath("file"))
+			assert.NoError(t, err)
+			dstStat, err := os.Stat(dst.resolvePath("copy"))
+			assert.NoError(t, err)
+			assert.Equal(t, test.linked, os.SameFile(srcStat, dstStat))
+			assert.Equal(t, []string{"copy"}, listCompactTree(t, dstRoot))
+
+			// rewriting the source leaves the copy alone, even if they share an inode
+			assert.NoError(t, src.SetString("file", "new"))
+			value, err = dst.GetString("copy")
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+
+			// missing sources do not fall back
+			assert.True(t, isNotFound(src.CopyFile("missing", dst, "other")))
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+// Called by DiffWithProgress after each name is classified, with the number of names
+// classified so far out of total. Names are compared in sorted order, so name also tells
+// how far a huge diff has come.
+type DiffProgress func(compared int, total int, name FSName)
+
+// Compares the files under prefix on a and b, e.g. before cutting over to another backend.
+// Files present on both sides are compared by size first and then by SHA-256 digest, streaming
+// their content, so large files are never buffered. All returned lists are sorted.
+func Diff(a FileSystem, b FileSystem, prefix FSName) (onlyInA []FSName, onlyInB []FSName, different []FSName, err error) {
+	return DiffWithProgress(a, b, prefix, nil)
+}
+
+// Like Diff, but reports progress to progress, which may be nil.
+func DiffWithProgress(a FileSystem, b FileSystem, prefix FSName, progress DiffProgress) (onlyInA []FSName, onlyInB []FSName, different []FSName, err error) {
+	namesA, err := listForDiff(a, prefix)
+	if err != nil {
+		return nil, nil, nil, errors.WithMessage(err, "list a")
+	}
+	namesB, err := listForDiff(b, prefix)
+	if err != nil {
+		return nil, nil, nil, errors.WithMessage(err, "list b")
+	}
+	inA := make(map[FSName]bool, len(namesA))
+	for _, name := range namesA {
+		inA[name] = true
+	}
+	inB := make(map[FSName]bool, len(namesB))
+	names := namesA
+	for _, name := range namesB {
+		inB[name] = true
+		if !inA[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+	for i, name := range names {
+		switch {
+		case !inB[name]:
+			onlyInA = append(onlyInA, name)
+		case !inA[name]:
+			onlyInB = append(onlyInB, name)
+		default:
+			equal, err := filesEqual(a, b, name)
+			if err != nil {
+				return nil, nil, nil, errors.WithMessagef(err, "compare %s", name)
+			}
+			if !equal {
+				different = append(different, name)
+			}
+		}
+		if progress != nil {
+			progress(i+1, len(names), name)
+		}
+	}
+	return onlyInA, onlyInB, different, nil
+}
+
+// A prefix missing on one side means that side has no files under it.
+func listForDiff(fs FileSystem, prefix FSName) ([]FSName, error) {
+	names, err := fs.ListFiles(prefix)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	return names, err
+}
+
+func filesEqual(a FileSystem, b FileSystem, name FSName) (bool, error) {
+	statA, err := a.Stat(name)
+	if err != nil {
+		return false, errors.WithMessage(err, "stat a")
+	}
+	statB, err := b.Stat(name)
+	if err != nil {
+		return false, errors.WithMessage(err, "stat b")
+	}
+	if statA.Size() != statB.Size() {
+		return false, nil
+	}
+	digestA, err := fileChecksum(a, name)
+	if err != nil {
+		return false, errors.WithMessage(err, "hash a")
+	}
+	digestB, err := fileChecksum(b, name)
+	if err != nil {
+		return false, errors.WithMessage(err, "hash b")
+	}
+	return digestA == digestB, nil
+}
+
+// Returns the SHA-256 digest of name, from its checksum sidecar if fs is a
+// ChecksumFileSystem, otherwise by reading the file in full. Files without a sidecar, e.g.
+// written before the ChecksumFileSystem was added, are read in full as well.
+func fileChecksum(fs FileSystem, name FSName) (string, error) {
+	if checksum, ok := fs.(*ChecksumFileSystem); ok {
+		digest, err := checksum.GetChecksum(name)
+		if !isNotFound(err) {
+			return digest, err
+		}
+	}
+	return hashFromFileSystem(fs, name, sha256.New())
+}
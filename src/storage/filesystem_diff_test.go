@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name      string
+		a         map[FSName]string
+		b         map[FSName]string
+		onlyInA   []FSName
+		onlyInB   []FSName
+		different []FSName
+	}{
+		{"empty", nil, nil, nil, nil, nil},
+		{"equal", map[FSName]string{"dir/a": "1", "b": "2"}, map[FSName]string{"dir/a": "1", "b": "2"}, nil, nil, nil},
+		{"one sided", map[FSName]string{"a": "1"}, map[FSName]string{"b": "2"}, []FSName{"a"}, []FSName{"b"}, nil},
+		{"different size", map[FSName]string{"a": "1"}, map[FSName]string{"a": "12"}, nil, nil, []FSName{"a"}},
+		{"different content", map[FSName]string{"a": "1", "b": "2"}, map[FSName]string{"a": "3", "b": "2"}, nil, nil, []FSName{"a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := NewFileSystemBase(t.TempDir())
+			b := NewFileSystemBase(t.TempDir())
+			for fs, files := range map[*FileSystemBase]map[FSName]string{a: test.a, b: test.b} {
+				for name, value := range files {
+					assert.NoError(t, fs.MkDir("dir"))
+					assert.NoError(t, fs.SetString(name, value))
+				}
+			}
+			onlyInA, onlyInB, different, err := Diff(a, b, "")
+			assert.NoError(t, err)
+			assert.Equal(t, test.onlyInA, onlyInA)
+			assert.Equal(t, test.onlyInB, onlyInB)
+			assert.Equal(t, test.different, different)
+		})
+	}
+}
+
+func TestDiffMissingPrefix(t *testing.T) {
+	a := NewFileSystemBase(t.TempDir())
+	b := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, a.MkDir("dir"))
+	assert.NoError(t, a.SetString("dir/file", "value"))
+	onlyInA, onlyInB, different, err := Diff(a, b, "dir")
+	assert.NoError(t, err)
+	assert.Equal(t, []FSName{"dir/file"}, onlyInA)
+	assert.Empty(t, onlyInB)
+	assert.Empty(t, different)
+}
+
+func TestDiffChecksumWithoutSidecar(t *testing.T) {
+	base := NewFileSystemBase(t.TempDir())
+	// written before the ChecksumFileSystem was added, so it has no sidecar
+	assert.NoError(t, base.SetString("old", "value"))
+	a := NewChecksumFileSystem(base)
+	assert.NoError(t, a.SetString("new", "value"))
+	b := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, b.SetString("old", "other"))
+	assert.NoError(t, b.SetString("new", "value"))
+	onlyInA, onlyInB, different, err := Diff(a, b, "")
+	assert.NoError(t, err)
+	assert.Empty(t, onlyInA)
+	assert.Empty(t, onlyInB)
+	assert.Equal(t, []FSName{"old"}, different)
+}
+
+func TestDiffWithProgress(t *testing.T) {
+	a := NewFileSystemBase(t.TempDir())
+	b := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, a.SetString("a", "1"))
+	assert.NoError(t, b.SetString("b", "2"))
+	assert.NoError(t, b.SetString("c", "3"))
+	var names []FSName
+	_, _, _, err := DiffWithProgress(a, b, "", func(compared int, total int, name FSName) {
+		assert.Equal(t, len(names)+1, compared)
+		assert.Equal(t, 3, total)
+		names = append(names, name)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []FSName{"a", "b", "c"}, names)
+}
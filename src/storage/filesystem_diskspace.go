@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io"
+)
+
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+var errDiskSpaceUnsupported = errors.New("disk space check unsupported on this platform")
+
+// Fails writes with ErrInsufficientSpace when they would leave less than minFreeBytes available,
+// so there is always enough headroom left to delete files and recover. On platforms where free
+// space cannot be queried, the check is skipped.
+func WithMinFreeBytes(minFreeBytes int64) FileSystemOption {
+	return func(a *FileSystemBase) {
+		a.minFreeBytes = minFreeBytes
+	}
+}
+
+func (a *FileSystemBase) freeBytesOrDefault() func(path string) (int64, error) {
+	if a.freeBytes == nil {
+		return freeDiskBytes
+	}
+	return a.freeBytes
+}
+
+// Checks that writing size more bytes into dir keeps the free space above the minimum.
+func (a *FileSystemBase) checkFreeSpace(dir string, size int64) error {
+	if a.minFreeBytes <= 0 {
+		return nil
+	}
+	free, err := a.freeBytesOrDefault()(dir)
+	if errors.Is(err, errDiskSpaceUnsupported) {
+		return nil
+	} else if err != nil {
+		return errors.WithMessage(err, "get free disk space")
+	}
+	if free-size < a.minFreeBytes {
+		return errors.WithMessagef(ErrInsufficientSpace, "%d bytes free, %d required", free-size, a.minFreeBytes)
+	}
+	return nil
+}
+
+// Bytes written between the checks of writes larger than their announced size, so a stream of
+// unknown size fails soon after the disk fills up, rather than once all of it is written.
+const freeSpaceCheckBytes = 4 << 20
+
+// Checks the free space of dir each time remaining more bytes were written. The written bytes
+// already take up their space, so they are not added to the check.
+type freeSpaceWriter struct {
+	io.Writer
+	a         *FileSystemBase
+	dir       string
+	remaining int64
+}
+
+func (w *freeSpaceWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if w.remaining -= int64(n); err == nil && w.remaining <= 0 {
+		w.remaining = freeSpaceCheckBytes
+		err = w.a.checkFreeSpace(w.dir, 0)
+	}
+	return n, err
+}
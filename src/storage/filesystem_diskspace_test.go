@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+// Counts the bytes read, without implementing io.WriterTo, so copies go through the buffer.
+type countingReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+func TestMinFreeBytes(t *testing.T) {
+	const minFree = 100
+	tests := []struct {
+		name   string
+		size   int64
+		length int64
+		// free space before the write, which shrinks by every byte read
+		free   int64
+		fails  bool
+		unread bool
+	}{
+		{"sized", 10, 10, minFree + 10, false, false},
+		{"sized too large", 10, 10, minFree + 9, true, true},
+		{"unsized", -1, 3 * freeSpaceCheckBytes, minFree + 3*freeSpaceCheckBytes, false, false},
+		{"unsized too large", -1, 4 * freeSpaceCheckBytes, minFree + 2*freeSpaceCheckBytes, true, false},
+		{"longer than sized", freeSpaceCheckBytes, 4 * freeSpaceCheckBytes, minFree + 2*freeSpaceCheckBytes, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			fs := NewFileSystemBase(root, WithMinFreeBytes(minFree))
+			value := &countingReader{reader: bytes.NewReader(make([]byte, test.length))}
+			fs.freeBytes = func(string) (int64, error) {
+				return test.free - value.read, nil
+			}
+			err := fs.SetFileSized("file", value, test.size)
+			if !test.fails {
+				assert.NoError(t, err)
+				stat, err := fs.Stat("file")
+				assert.NoError(t, err)
+				assert.Equal(t, test.length, stat.Size())
+				return
+			}
+			assert.ErrorIs(t, err, ErrInsufficientSpace)
+			if test.unread {
+				assert.Zero(t, value.read)
+			} else {
+				// fails at the first check past the free space, not after reading everything
+				assert.Equal(t, 3*int64(freeSpaceCheckBytes), value.read)
+			}
+			// neither the file nor its temp file is left
+			assert.Empty(t, listCompactTree(t, root))
+		})
+	}
+}
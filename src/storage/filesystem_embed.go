@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"bytes"
+	"embed"
+	"github.com/pkg/errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrReadOnly = errors.New("read-only file system")
+
+// Serves files compiled into the binary. All writes fail with ErrReadOnly.
+type EmbedFileSystem struct {
+	files fs.FS
+}
+
+// Serves the files under root in the given embed.FS.
+func NewEmbedFileSystem(files embed.FS, root string) (*EmbedFileSystem, error) {
+	sub, err := fs.Sub(files, root)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "sub %s", root)
+	}
+	return &EmbedFileSystem{files: sub}, nil
+}
+
+func (e *EmbedFileSystem) resolvePath(name FSName) string {
+	resolved := strings.TrimPrefix(path.Clean("/"+string(name)), "/")
+	if resolved == "" {
+		return "."
+	}
+	return resolved
+}
+
+func (e *EmbedFileSystem) GetString(name FSName) (string, error) {
+	data, err := fs.ReadFile(e.files, e.resolvePath(name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (e *EmbedFileSystem) GetBytes(name FSName) ([]byte, error) {
+	return fs.ReadFile(e.files, e.resolvePath(name))
+}
+
+func (e *EmbedFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	file, err := e.files.Open(e.resolvePath(name))
+	if err != nil {
+		return nil, err
+	}
+	// files from embed.FS already support seeking and ReadAt
+	if readonlyFile, ok := file.(ReadonlyFile); ok {
+		return readonlyFile, nil
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return newReaderFile(stat.Name(), bytes.NewReader(data), stat.ModTime()), nil
+}
+
+func (e *EmbedFileSystem) GetSeekableFile(name FSName) (io.ReadSeekCloser, int64, error) {
+	file, err := e.GetFile(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, stat.Size(), nil
+}
+
+func (e *EmbedFileSystem) WriteTo(name FSName, w io.Writer, offset int64, length int64) (int64, error) {
+	return writeFileTo(e, name, w, offset, length)
+}
+
+func (e *EmbedFileSystem) StreamArchive(prefix FSName, format ArchiveFormat, w io.Writer) error {
+	return streamArchive(e, prefix, format, w)
+}
+
+func (e *EmbedFileSystem) SetString(name FSName, value string) error {
+	return ErrReadOnly
+}
+
+func (e *EmbedFileSystem) SetFile(name FSName, value io.Reader) error {
+	return ErrReadOnly
+}
+
+func (e *EmbedFileSystem) RemoveFile(name FSName) error {
+	return ErrReadOnly
+}
+
+func (e *EmbedFileSystem) RemoveFileIfExists(name FSName) error {
+	return ErrReadOnly
+}
+
+func (e *EmbedFileSystem) Stat(name FSName) (os.FileInfo, error) {
+	return fs.Stat(e.files, e.resolvePath(name))
+}
+
+func (e *EmbedFileSystem) MkDir(name FSName) error {
+	return ErrReadOnly
+}
+
+func (e *EmbedFileSystem) ReadDir(name FSName) ([]os.DirEntry, error) {
+	entries, err := fs.ReadDir(e.files, e.resolvePath(name))
+	if err != nil {
+		return nil, err
+	}
+	return removeHiddenEntries(entries), nil
+}
+
+func removeHiddenEntries(entries []fs.DirEntry) []fs.DirEntry {
+	var visible []fs.DirEntry
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			visible = append(visible, entry)
+		}
+	}
+	return visible
+}
+
+func (e *EmbedFileSystem) ListFiles(prefix FSName) ([]FSName, error) {
+	return e.ListModifiedSince(prefix, time.Time{})
+}
+
+func (e *EmbedFileSystem) ListModifiedSince(prefix FSName, since time.Time) ([]FSName, error) {
+	root := e.resolvePath(prefix)
+	var names []FSName
+	err := fs.WalkDir(e.files, root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		// embedded files have no mod time, so they only count as modified for the zero time
+		if !since.IsZero() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if !info.ModTime().After(since) {
+				return nil
+			}
+		}
+		relPath := filePath
+		if root != "." {
+			relPath = strings.TrimPrefix(strings.TrimPrefix(filePath, root), "/")
+		}
+		names = append(names, FSName(path.Join(string(prefix), relPath)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (e *EmbedFileSystem) TreeHash(prefix FSName) (string, error) {
+	return treeHash(e, prefix)
+}
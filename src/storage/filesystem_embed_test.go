@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"embed"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+//go:embed all:testdata/embed
+var testEmbedFiles embed.FS
+
+func newTestEmbedFileSystem(t *testing.T) *EmbedFileSystem {
+	e, err := NewEmbedFileSystem(testEmbedFiles, "testdata/embed")
+	assert.NoError(t, err)
+	return e
+}
+
+func TestEmbedReads(t *testing.T) {
+	e := newTestEmbedFileSystem(t)
+	tests := []struct {
+		name  string
+		file  FSName
+		value string
+	}{
+		{"file", "file.txt", "value\n"},
+		{"nested", "dir/nested.txt", "nested"},
+		{"leading slash", "/dir/nested.txt", "nested"},
+		{"escaping root", "../../file.txt", "value\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := e.GetString(test.file)
+			assert.NoError(t, err)
+			assert.Equal(t, strings.TrimSpace(test.value), value)
+			data, err := e.GetBytes(test.file)
+			assert.NoError(t, err)
+			assert.Equal(t, test.value, string(data))
+			file, err := e.GetFile(test.file)
+			assert.NoError(t, err)
+			p := make([]byte, 3)
+			n, err := file.ReadAt(p, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, test.value[1:1+n], string(p[:n]))
+			assert.NoError(t, file.Close())
+			var buffer bytes.Buffer
+			written, err := e.WriteTo(test.file, &buffer, 1, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(test.value)-1), written)
+			assert.Equal(t, test.value[1:], buffer.String())
+		})
+	}
+	_, err := e.GetString("missing")
+	assert.True(t, isNotFound(err))
+}
+
+func TestEmbedReadOnly(t *testing.T) {
+	e := newTestEmbedFileSystem(t)
+	tests := []struct {
+		name  string
+		write func() error
+	}{
+		{"set string", func() error { return e.SetString("file.txt", "other") }},
+		{"set file", func() error { return e.SetFile("file.txt", strings.NewReader("other")) }},
+		{"remove file", func() error { return e.RemoveFile("file.txt") }},
+		{"remove file if exists", func() error { return e.RemoveFileIfExists("file.txt") }},
+		{"mkdir", func() error { return e.MkDir("new") }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.ErrorIs(t, test.write(), ErrReadOnly)
+		})
+	}
+	value, err := e.GetString("file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func TestEmbedListing(t *testing.T) {
+	e := newTestEmbedFileSystem(t)
+	names, err := e.ListFiles("")
+	assert.NoError(t, err)
+	assert.Equal(t, []FSName{"dir/nested.txt", "file.txt"}, names)
+	names, err = e.ListFiles("dir")
+	assert.NoError(t, err)
+	assert.Equal(t, []FSName{"dir/nested.txt"}, names)
+	// embedded files have no mod time
+	names, err = e.ListModifiedSince("", time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+
+	entries, err := e.ReadDir("")
+	assert.NoError(t, err)
+	var entryNames []string
+	for _, entry := range entries {
+		entryNames = append(entryNames, entry.Name())
+	}
+	assert.Equal(t, []string{"dir", "file.txt"}, entryNames)
+
+	_, err = e.ListFiles("missing")
+	assert.True(t, isNotFound(err))
+}
+
+func TestEmbedSeekableFile(t *testing.T) {
+	e := newTestEmbedFileSystem(t)
+	file, size, err := e.GetSeekableFile("dir/nested.txt")
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.Equal(t, int64(len("nested")), size)
+	_, err = file.Seek(3, io.SeekStart)
+	assert.NoError(t, err)
+	rest, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "ted", string(rest))
+}
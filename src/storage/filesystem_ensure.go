@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"os"
+)
+
+// Creates name with the content from generate, only if name does not exist yet, e.g. to seed
+// a default config exactly once. generate is not called if name exists. Returns whether the
+// file was created. Within a process, the check and the write happen under the write lock.
+// The file is committed with a hardlink, which fails if the target exists, so concurrent
+// processes sharing the store also create it only once. Where hardlinks are unsupported,
+// only writers within this process are excluded.
+func (a *FileSystemBase) EnsureFile(name FSName, generate func() (io.ReadSeeker, error)) (_ bool, err error) {
+	defer a.trace("ensure file", name)(&err)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	targetPath := a.resolveWritePath(name)
+	if _, err := os.Stat(targetPath); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	value, err := generate()
+	if err != nil {
+		return false, errors.WithMessage(err, "generate file")
+	}
+	tempPath, err := a.writeTempFile(name, value, -1)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tempPath)
+	err = os.Link(tempPath, targetPath)
+	if err == nil {
+		return true, nil
+	} else if os.IsExist(err) {
+		return false, nil
+	}
+	a.warn(err, "hardlink failed, falling back to rename")
+	if err := a.commitTempFile(tempPath, targetPath); err != nil {
+		return false, errors.WithMessage(err, "create file")
+	}
+	return true, nil
+}
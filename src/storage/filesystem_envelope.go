@@ -0,0 +1,478 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+var ErrDecryptFailed = errors.New("decryption failed")
+
+const (
+	envelopeChunkSize = 64 << 10
+	envelopeKeyIdSize = 8
+	envelopeKeySize   = 32
+)
+
+// Encrypts every file with its own random data key using chunked AES-256-GCM, so files can
+// be streamed and seeked without decrypting them whole. Data keys are wrapped with the master
+// key and stored in a hidden keyring sidecar, which lets RotateMasterKey re-wrap only the data
+// keys instead of re-encrypting the contents. Each file starts with the id of its data key
+// in the keyring, so a crash between writing the keyring and the file leaves the old content
+// readable.
+type EnvelopeEncryptedFileSystem struct {
+	FileSystem
+	// write-locked while rotating the master key
+	mu        sync.RWMutex
+	masterKey []byte
+	// held per name from adding a key to the keyring until it is pruned, so concurrent
+	// writes cannot prune the key of the content that was committed last, and shared by
+	// reads from opening a file until its data key is read
+	names nameLocks
+}
+
+// masterKey must be 16, 24 or 32 bytes long, selecting AES-128, AES-192 or AES-256 for
+// wrapping the data keys.
+func NewEnvelopeEncryptedFileSystem(fs FileSystem, masterKey []byte) (*EnvelopeEncryptedFileSystem, error) {
+	if _, err := newAEAD(masterKey); err != nil {
+		return nil, errors.WithMessage(err, "master key")
+	}
+	return &EnvelopeEncryptedFileSystem{FileSystem: fs, masterKey: masterKey}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func keyringName(name FSName) FSName {
+	return sidecarName(name, "keys")
+}
+
+func wrapKey(masterKey []byte, dataKey []byte) ([]byte, error) {
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func unwrapKey(masterKey []byte, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrDecryptFailed
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return dataKey, nil
+}
+
+// Maps hex-encoded key ids to wrapped data keys.
+type keyring map[string][]byte
+
+func (e *EnvelopeEncryptedFileSystem) getKeyring(name FSName) (keyring, error) {
+	data, err := e.FileSystem.GetBytes(keyringName(name))
+	if isNotFound(err) {
+		return keyring{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	keys := keyring{}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, errors.WithMessage(err, "unmarshal keyring")
+	}
+	return keys, nil
+}
+
+func (e *EnvelopeEncryptedFileSystem) setKeyring(name FSName, keys keyring) error {
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return errors.WithMessage(err, "marshal keyring")
+	}
+	return e.FileSystem.SetFile(keyringName(name), bytes.NewReader(data))
+}
+
+func (e *EnvelopeEncryptedFileSystem) SetString(name FSName, value string) error {
+	return e.SetFile(name, strings.NewReader(strings.TrimSpace(value)))
+}
+
+func (e *EnvelopeEncryptedFileSystem) SetFile(name FSName, value io.Reader) error {
+	// rotation must not re-wrap the keyring between the two writes below
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	defer e.names.lock(string(name))()
+	keyId := make([]byte, envelopeKeyIdSize)
+	dataKey := make([]byte, envelopeKeySize)
+	if _, err := rand.Read(keyId); err != nil {
+		return err
+	}
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	wrapped, err := wrapKey(e.masterKey, dataKey)
+	if err != nil {
+		return errors.WithMessage(err, "wrap data key")
+	}
+	keys, err := e.getKeyring(name)
+	if err != nil {
+		return errors.WithMessage(err, "get keyring")
+	}
+	// keep the current key, the file still needs it until it is replaced
+	keys[hex.EncodeToString(keyId)] = wrapped
+	if err := e.setKeyring(name, keys); err != nil {
+		return errors.WithMessage(err, "save keyring")
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(encryptStream(writer, value, keyId, aead))
+	}()
+	err = e.FileSystem.SetFile(name, reader)
+	// unblock the encryption if the write failed first
+	reader.CloseWithError(errors.New("write aborted"))
+	if err != nil {
+		return err
+	}
+	if err := e.setKeyring(name, keyring{hex.EncodeToString(keyId): wrapped}); err != nil {
+		return errors.WithMessage(err, "prune keyring")
+	}
+	return nil
+}
+
+// Every chunk is sealed separately with its index as nonce, and the last one is marked,
+// so reordered, dropped or truncated chunks fail to decrypt.
+func chunkNonce(aead cipher.AEAD, index int64, final bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func encryptStream(w io.Writer, r io.Reader, keyId []byte, aead cipher.AEAD) error {
+	if _, err := w.Write(keyId); err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(r, envelopeChunkSize)
+	chunk := make([]byte, envelopeChunkSize)
+	var sealed []byte
+	for index := int64(0); ; index++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		final := n < envelopeChunkSize
+		if !final {
+			if _, err := reader.Peek(1); err == io.EOF {
+				final = true
+			} else if err != nil {
+				return err
+			}
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(aead, index, final), chunk[:n], nil)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// Returns the plaintext size and chunk count of a file of encryptedSize bytes.
+func envelopeLayout(aead cipher.AEAD, encryptedSize int64) (int64, int64, error) {
+	sealedChunkSize := int64(envelopeChunkSize + aead.Overhead())
+	payload := encryptedSize - envelopeKeyIdSize
+	// even an empty file has one sealed chunk
+	if payload < int64(aead.Overhead()) {
+		return 0, 0, ErrDecryptFailed
+	}
+	chunks := (payload + sealedChunkSize - 1) / sealedChunkSize
+	lastChunk := payload - (chunks-1)*sealedChunkSize
+	if lastChunk < int64(aead.Overhead()) {
+		return 0, 0, ErrDecryptFailed
+	}
+	return (chunks-1)*envelopeChunkSize + lastChunk - int64(aead.Overhead()), chunks, nil
+}
+
+func (e *EnvelopeEncryptedFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	// the keyring must be unwrapped with the master key it was read with
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	defer e.names.rlock(string(name))()
+	file, err := e.FileSystem.GetFile(name)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := e.decryptFile(name, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return decrypted, nil
+}
+
+func (e *EnvelopeEncryptedFileSystem) decryptFile(name FSName, file ReadonlyFile) (ReadonlyFile, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	keyId := make([]byte, envelopeKeyIdSize)
+	if _, err := file.ReadAt(keyId, 0); err != nil {
+		return nil, errors.WithMessage(ErrDecryptFailed, "read key id")
+	}
+	dataKey, err := e.getDataKey(name, keyId)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	size, chunks, err := envelopeLayout(aead, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	reader := &envelopeReader{file: file, aead: aead, size: size, chunks: chunks, cachedIndex: -1}
+	return &envelopeFile{
+		SectionReader: io.NewSectionReader(reader, 0, size),
+		file:          file,
+		info:          &fileInfo{name: stat.Name(), size: size, modTime: stat.ModTime()},
+	}, nil
+}
+
+// Must be called with the read lock and the shared lock of name held.
+func (e *EnvelopeEncryptedFileSystem) getDataKey(name FSName, keyId []byte) ([]byte, error) {
+	keys, err := e.getKeyring(name)
+	if err != nil {
+		return nil, errors.WithMessage(err, "get keyring")
+	}
+	wrapped, ok := keys[hex.EncodeToString(keyId)]
+	if !ok {
+		return nil, errors.WithMessagef(ErrDecryptFailed, "data key %x not found", keyId)
+	}
+	dataKey, err := unwrapKey(e.masterKey, wrapped)
+	if err != nil {
+		return nil, errors.WithMessage(err, "unwrap data key")
+	}
+	return dataKey, nil
+}
+
+type envelopeFile struct {
+	*io.SectionReader
+	file ReadonlyFile
+	info os.FileInfo
+}
+
+func (f *envelopeFile) Close() error {
+	return f.file.Close()
+}
+
+func (f *envelopeFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+type envelopeReader struct {
+	file   ReadonlyFile
+	aead   cipher.AEAD
+	size   int64
+	chunks int64
+	// the last decrypted chunk, since sequential reads usually hit it again
+	mu          sync.Mutex
+	cachedIndex int64
+	cached      []byte
+}
+
+func (r *envelopeReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for n < len(p) && off < r.size {
+		index := off / envelopeChunkSize
+		chunk, err := r.chunk(index)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], chunk[off-index*envelopeChunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *envelopeReader) chunk(index int64) ([]byte, error) {
+	if index == r.cachedIndex {
+		return r.cached, nil
+	}
+	sealedChunkSize := int64(envelopeChunkSize + r.aead.Overhead())
+	final := index == r.chunks-1
+	sealedSize := sealedChunkSize
+	if final {
+		sealedSize = r.size - index*envelopeChunkSize + int64(r.aead.Overhead())
+	}
+	sealed := make([]byte, sealedSize)
+	if n, err := r.file.ReadAt(sealed, envelopeKeyIdSize+index*sealedChunkSize); n < len(sealed) {
+		return nil, err
+	}
+	chunk, err := r.aead.Open(sealed[:0], chunkNonce(r.aead, index, final), sealed, nil)
+	if err != nil {
+		return nil, errors.WithMessagef(ErrDecryptFailed, "chunk %d", index)
+	}
+	r.cachedIndex, r.cached = index, chunk
+	return chunk, nil
+}
+
+func (e *EnvelopeEncryptedFileSystem) GetBytes(name FSName) ([]byte, error) {
+	file, err := e.GetFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func (e *EnvelopeEncryptedFileSystem) GetString(name FSName) (string, error) {
+	data, err := e.GetBytes(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (e *EnvelopeEncryptedFileSystem) GetSeekableFile(name FSName) (io.ReadSeekCloser, int64, error) {
+	file, err := e.GetFile(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, stat.Size(), nil
+}
+
+// Offset and length refer to the decrypted content.
+func (e *EnvelopeEncryptedFileSystem) WriteTo(name FSName, w io.Writer, offset int64, length int64) (int64, error) {
+	return writeFileTo(e, name, w, offset, length)
+}
+
+// Archives the decrypted contents.
+func (e *EnvelopeEncryptedFileSystem) StreamArchive(prefix FSName, format ArchiveFormat, w io.Writer) error {
+	return streamArchive(e, prefix, format, w)
+}
+
+// Reports the size of the decrypted content.
+func (e *EnvelopeEncryptedFileSystem) Stat(name FSName) (os.FileInfo, error) {
+	stat, err := e.FileSystem.Stat(name)
+	if err != nil || stat.IsDir() {
+		return stat, err
+	}
+	// the layout only depends on the overhead, which is the same for every GCM key
+	aead, err := newAEAD(make([]byte, envelopeKeySize))
+	if err != nil {
+		return nil, err
+	}
+	size, _, err := envelopeLayout(aead, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: stat.Name(), size: size, modTime: stat.ModTime()}, nil
+}
+
+func (e *EnvelopeEncryptedFileSystem) RemoveFile(name FSName) error {
+	defer e.names.lock(string(name))()
+	if err := e.FileSystem.RemoveFile(name); err != nil {
+		return err
+	}
+	if err := e.FileSystem.RemoveFileIfExists(keyringName(name)); err != nil {
+		return errors.WithMessage(err, "remove keyring")
+	}
+	return nil
+}
+
+// Hashes the decrypted contents, since the ciphertext changes with every write, even of
+// the same content.
+func (e *EnvelopeEncryptedFileSystem) TreeHash(prefix FSName) (string, error) {
+	return treeHash(e, prefix)
+}
+
+func (e *EnvelopeEncryptedFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(e, name)
+}
+
+// Re-wraps the data keys of all files that were wrapped with oldKey using newKey. Keys that
+// are already wrapped with newKey are left as they are, so an interrupted rotation can be
+// run again with the same keys. Reads and writes wait until the rotation is done.
+func (e *EnvelopeEncryptedFileSystem) RotateMasterKey(oldKey []byte, newKey []byte) error {
+	if _, err := newAEAD(newKey); err != nil {
+		return errors.WithMessage(err, "new master key")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	names, err := e.FileSystem.ListFiles("")
+	if err != nil {
+		return errors.WithMessage(err, "list files")
+	}
+	for _, name := range names {
+		if err := e.rewrapKeyring(name, oldKey, newKey); err != nil {
+			return errors.WithMessagef(err, "rotate %s", name)
+		}
+	}
+	e.masterKey = newKey
+	return nil
+}
+
+func (e *EnvelopeEncryptedFileSystem) rewrapKeyring(name FSName, oldKey []byte, newKey []byte) error {
+	keys, err := e.getKeyring(name)
+	if err != nil {
+		return errors.WithMessage(err, "get keyring")
+	}
+	changed := false
+	for id, wrapped := range keys {
+		dataKey, err := unwrapKey(oldKey, wrapped)
+		if err != nil {
+			if _, err := unwrapKey(newKey, wrapped); err == nil {
+				continue
+			}
+			return errors.WithMessagef(err, "unwrap data key %s", id)
+		}
+		if keys[id], err = wrapKey(newKey, dataKey); err != nil {
+			return errors.WithMessagef(err, "wrap data key %s", id)
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return e.setKeyring(name, keys)
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestEnvelopeFileSystem(t *testing.T) *EnvelopeEncryptedFileSystem {
+	e, err := NewEnvelopeEncryptedFileSystem(NewFileSystemBase(t.TempDir()), bytes.Repeat([]byte("k"), 32))
+	assert.NoError(t, err)
+	return e
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"small", 100},
+		{"exact chunk", envelopeChunkSize},
+		{"multiple chunks", 3*envelopeChunkSize + 17},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := newTestEnvelopeFileSystem(t)
+			data := bytes.Repeat([]byte("x"), test.size)
+			assert.NoError(t, e.SetFile("file", bytes.NewReader(data)))
+			value, err := e.GetBytes("file")
+			assert.NoError(t, err)
+			assert.Equal(t, data, value)
+			stat, err := e.Stat("file")
+			assert.NoError(t, err)
+			assert.EqualValues(t, test.size, stat.Size())
+		})
+	}
+}
+
+func TestEnvelopeConcurrentWritesSameName(t *testing.T) {
+	e := newTestEnvelopeFileSystem(t)
+	for round := 0; round < 10; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				assert.NoError(t, e.SetString("file", fmt.Sprint("value", i)))
+			}(i)
+		}
+		wg.Wait()
+		value, err := e.GetString("file")
+		assert.NoError(t, err)
+		assert.Contains(t, value, "value")
+	}
+	keys, err := e.getKeyring("file")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+}
+
+// Calls afterOpen once a file has been opened, before GetFile returns.
+type openHookFileSystem struct {
+	FileSystem
+	afterOpen func()
+}
+
+func (h *openHookFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	file, err := h.FileSystem.GetFile(name)
+	if err == nil && h.afterOpen != nil {
+		h.afterOpen()
+	}
+	return file, err
+}
+
+func TestEnvelopeReadDuringWrite(t *testing.T) {
+	hook := &openHookFileSystem{FileSystem: NewFileSystemBase(t.TempDir())}
+	e, err := NewEnvelopeEncryptedFileSystem(hook, bytes.Repeat([]byte("k"), 32))
+	assert.NoError(t, err)
+	assert.NoError(t, e.SetString("file", "old"))
+	written := make(chan error, 1)
+	hook.afterOpen = func() {
+		hook.afterOpen = nil
+		// a write that commits and prunes the keyring between opening the old content and
+		// reading its data key, unless it waits for the read
+		go func() {
+			written <- e.SetString("file", "new")
+		}()
+		select {
+		case err := <-written:
+			written <- err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	value, err := e.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "old", value)
+	assert.NoError(t, <-written)
+	value, err = e.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", value)
+}
+
+func TestEnvelopeTreeHashStableAcrossRewrites(t *testing.T) {
+	e := newTestEnvelopeFileSystem(t)
+	assert.NoError(t, e.SetString("file", "value"))
+	first, err := e.TreeHash("")
+	assert.NoError(t, err)
+	assert.NoError(t, e.SetString("file", "value"))
+	second, err := e.TreeHash("")
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+}
+
+func TestEnvelopeRotateMasterKey(t *testing.T) {
+	e := newTestEnvelopeFileSystem(t)
+	assert.NoError(t, e.SetString("file", "value"))
+	assert.NoError(t, e.RotateMasterKey(bytes.Repeat([]byte("k"), 32), bytes.Repeat([]byte("n"), 32)))
+	value, err := e.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"path"
+	"time"
+)
+
+// How long an idempotency key is remembered after its write by default.
+const defaultIdempotencyKeyTTL = 24 * time.Hour
+
+const idempotencyKeysDir = FSName(".idempotency")
+
+type idempotencyRecord struct {
+	Name    FSName    `json:"name"`
+	Expires time.Time `json:"expires"`
+}
+
+// Sets how long SetFileIdempotent remembers a key after its write, 24 hours by default.
+func WithIdempotencyTTL(ttl time.Duration) FileSystemOption {
+	return func(a *FileSystemBase) {
+		a.idempotencyTTL = ttl
+	}
+}
+
+func (a *FileSystemBase) idempotencyTTLOrDefault() time.Duration {
+	if a.idempotencyTTL <= 0 {
+		return defaultIdempotencyKeyTTL
+	}
+	return a.idempotencyTTL
+}
+
+// Keys are hashed, so any string can be used as a file name.
+func idempotencyRecordName(key string) FSName {
+	digest := sha256.Sum256([]byte(key))
+	return FSName(path.Join(string(idempotencyKeysDir), hex.EncodeToString(digest[:])))
+}
+
+// Like SetFile, but writes value only if idempotencyKey was not used for a write within the
+// idempotency TTL, e.g. to store a retried upload once. Returns false if the write was
+// skipped. Used keys are persisted in a hidden dir, so retries are recognized across
+// restarts. Writes with the same key are serialized, others run concurrently. The key is
+// recorded after the write, so if the process crashes in between, a retry writes the same
+// value again.
+func (a *FileSystemBase) SetFileIdempotent(name FSName, value io.ReadSeeker, idempotencyKey string) (bool, error) {
+	recordName := idempotencyRecordName(idempotencyKey)
+	defer a.idempotencyKeys.lock(string(recordName))()
+	expired, err := a.isIdempotencyRecordExpired(recordName)
+	if isNotFound(err) {
+		expired = true
+	} else if err != nil {
+		return false, err
+	}
+	if !expired {
+		return false, nil
+	}
+	if err := a.SetFile(name, value); err != nil {
+		return false, err
+	}
+	data, err := json.Marshal(idempotencyRecord{Name: name, Expires: time.Now().Add(a.idempotencyTTLOrDefault())})
+	if err != nil {
+		return false, errors.WithMessage(err, "marshal idempotency record")
+	}
+	if err := a.MkDir(idempotencyKeysDir); err != nil {
+		return false, errors.WithMessage(err, "create idempotency dir")
+	}
+	if err := a.SetString(recordName, string(data)); err != nil {
+		return false, errors.WithMessage(err, "save idempotency record")
+	}
+	return true, nil
+}
+
+func (a *FileSystemBase) isIdempotencyRecordExpired(recordName FSName) (bool, error) {
+	data, err := a.GetBytes(recordName)
+	if err != nil {
+		return false, err
+	}
+	var record idempotencyRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return false, errors.WithMessage(err, "unmarshal idempotency record")
+	}
+	return !time.Now().Before(record.Expires), nil
+}
+
+// Removes the records of idempotency keys whose TTL has passed and returns how many were
+// removed, e.g. from a periodic cleanup job.
+func (a *FileSystemBase) PurgeIdempotencyKeys() (int, error) {
+	entries, err := a.ReadDir(idempotencyKeysDir)
+	if isNotFound(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.WithMessage(err, "read idempotency dir")
+	}
+	purged := 0
+	for _, entry := range entries {
+		if entry.IsDir() || isTempFileName(entry.Name()) {
+			continue
+		}
+		recordName := FSName(path.Join(string(idempotencyKeysDir), entry.Name()))
+		removed, err := a.purgeIdempotencyRecord(recordName)
+		if err != nil {
+			return purged, errors.WithMessagef(err, "purge %s", entry.Name())
+		}
+		if removed {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (a *FileSystemBase) purgeIdempotencyRecord(recordName FSName) (bool, error) {
+	defer a.idempotencyKeys.lock(string(recordName))()
+	expired, err := a.isIdempotencyRecordExpired(recordName)
+	if isNotFound(err) {
+		return false, nil
+	} else if err != nil || !expired {
+		return false, err
+	}
+	return true, a.RemoveFileIfExists(recordName)
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetFileIdempotent(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		keys    []string
+		written []bool
+	}{
+		{"same key", time.Hour, []string{"a", "a"}, []bool{true, false}},
+		{"different keys", time.Hour, []string{"a", "b"}, []bool{true, true}},
+		{"expired key", time.Nanosecond, []string{"a", "a"}, []bool{true, true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := NewFileSystemBase(t.TempDir(), WithIdempotencyTTL(test.ttl))
+			for i, key := range test.keys {
+				written, err := fs.SetFileIdempotent("file", strings.NewReader("value"), key)
+				assert.NoError(t, err)
+				assert.Equal(t, test.written[i], written)
+			}
+		})
+	}
+}
+
+func TestSetFileIdempotentConcurrent(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	written := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := fs.SetFileIdempotent("file", strings.NewReader("value"), "key")
+			assert.NoError(t, err)
+			if ok {
+				mu.Lock()
+				written++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, written)
+}
+
+func TestPurgeIdempotencyKeys(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir(), WithIdempotencyTTL(time.Nanosecond))
+	purged, err := fs.PurgeIdempotencyKeys()
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+	for _, key := range []string{"a", "b"} {
+		_, err := fs.SetFileIdempotent("file", strings.NewReader("value"), key)
+		assert.NoError(t, err)
+	}
+	purged, err = fs.PurgeIdempotencyKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	entries, err := fs.ReadDir(idempotencyKeysDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const journalName = FSName(".journal.json")
+
+type journalEntry struct {
+	Name FSName `json:"name"`
+	// base name of the temp file, see tempFileDir
+	Temp string `json:"temp"`
+}
+
+// Makes SwapSet crash-consistent. Once all temp files are written, the pending renames are
+// recorded in a hidden journal before any of them is performed, and the journal is removed
+// when all are done. Constructing the wrapper replays an incomplete journal, rolling the
+// interrupted commit forward. A crash before the journal is written rolls the commit back,
+// since no target was touched yet and only hidden temp files are left behind.
+type JournaledFileSystem struct {
+	*FileSystemBase
+	// a single journal is shared by all commits
+	commitMu sync.Mutex
+	// set when a commit failed after writing the journal
+	pending bool
+}
+
+func NewJournaledFileSystem(base *FileSystemBase) (*JournaledFileSystem, error) {
+	j := &JournaledFileSystem{FileSystemBase: base}
+	if err := j.replay(); err != nil {
+		return nil, errors.WithMessage(err, "replay journal")
+	}
+	return j, nil
+}
+
+func (j *JournaledFileSystem) tempPath(entry journalEntry) string {
+	return filepath.Join(j.tempFileDir(j.resolvePath(entry.Name)), entry.Temp)
+}
+
+func (j *JournaledFileSystem) replay() error {
+	data, err := j.FileSystemBase.GetBytes(journalName)
+	if isNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var entries []journalEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return errors.WithMessage(err, "unmarshal journal")
+	}
+	j.mu.Lock()
+	for _, entry := range entries {
+		tempPath := j.tempPath(entry)
+		// a missing temp file was already renamed before the crash
+		if _, err := os.Stat(tempPath); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			j.mu.Unlock()
+			return errors.WithMessagef(err, "stat %s", entry.Temp)
+		}
+		if err := j.commitTempFile(tempPath, j.resolvePath(entry.Name)); err != nil {
+			j.mu.Unlock()
+			return errors.WithMessagef(err, "replace %s", entry.Name)
+		}
+	}
+	j.mu.Unlock()
+	return j.FileSystemBase.RemoveFile(journalName)
+}
+
+func (j *JournaledFileSystem) SwapSet(updates map[FSName]io.ReadSeeker) error {
+	j.commitMu.Lock()
+	defer j.commitMu.Unlock()
+	if j.pending {
+		if err := j.replay(); err != nil {
+			return errors.WithMessage(err, "replay journal")
+		}
+		j.pending = false
+	}
+	var entries []journalEntry
+	defer func() {
+		for _, entry := range entries {
+			os.Remove(j.tempPath(entry))
+		}
+	}()
+	for name, value := range updates {
+		tempPath, err := j.writeTempFile(name, value, -1)
+		if err != nil {
+			return errors.WithMessagef(err, "write %s", name)
+		}
+		entries = append(entries, journalEntry{Name: name, Temp: filepath.Base(tempPath)})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return errors.WithMessage(err, "marshal journal")
+	}
+	if err := j.FileSystemBase.SetFile(journalName, bytes.NewReader(data)); err != nil {
+		return errors.WithMessage(err, "write journal")
+	}
+	if err := j.commitEntries(entries); err != nil {
+		// keep the temp files, so the journal can still roll the commit forward
+		entries = nil
+		j.pending = true
+		return err
+	}
+	return j.FileSystemBase.RemoveFile(journalName)
+}
+
+func (j *JournaledFileSystem) commitEntries(entries []journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, entry := range entries {
+		if err := j.commitTempFile(j.tempPath(entry), j.resolvePath(entry.Name)); err != nil {
+			return errors.WithMessagef(err, "replace %s", entry.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+var errCrash = errors.New("simulated crash")
+
+// Lets the first renames succeed, including the one writing the journal, and fails the
+// rest, like a process dying mid-commit.
+func crashAfterRenames(base *FileSystemBase, renames int) {
+	base.replaceFile = func(source string, target string) error {
+		if renames == 0 {
+			return errCrash
+		}
+		renames--
+		return os.Rename(source, target)
+	}
+}
+
+func journalUpdates(value string) map[FSName]io.ReadSeeker {
+	return map[FSName]io.ReadSeeker{
+		"a":     strings.NewReader(value),
+		"b":     strings.NewReader(value),
+		"dir/c": strings.NewReader(value),
+	}
+}
+
+func assertJournalValues(t *testing.T, fs FileSystem, value string) {
+	for name := range journalUpdates(value) {
+		stored, err := fs.GetString(name)
+		assert.NoError(t, err)
+		assert.Equal(t, value, stored, name)
+	}
+}
+
+// Fails if temp files or the journal are left in the root or dir.
+func assertJournalClean(t *testing.T, root string) {
+	for _, dir := range []string{root, root + "/dir"} {
+		entries, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		for _, entry := range entries {
+			assert.False(t, isTempFileName(entry.Name()), entry.Name())
+			assert.NotEqual(t, string(journalName), entry.Name())
+		}
+	}
+}
+
+func TestJournalSwapSet(t *testing.T) {
+	root := t.TempDir()
+	base := NewFileSystemBase(root)
+	assert.NoError(t, base.MkDir("dir"))
+	j, err := NewJournaledFileSystem(base)
+	assert.NoError(t, err)
+	assert.NoError(t, j.SwapSet(journalUpdates("old")))
+	assert.NoError(t, j.SwapSet(journalUpdates("new")))
+	assertJournalValues(t, j, "new")
+	assertJournalClean(t, root)
+}
+
+func TestJournalReplayAfterCrash(t *testing.T) {
+	tests := []struct {
+		name    string
+		renames int
+		want    string
+	}{
+		// the journal itself is written through a rename
+		{"before journal", 0, "old"},
+		{"before first rename", 1, "new"},
+		{"after one rename", 2, "new"},
+		{"before last rename", 3, "new"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			base := NewFileSystemBase(root)
+			assert.NoError(t, base.MkDir("dir"))
+			j, err := NewJournaledFileSystem(base)
+			assert.NoError(t, err)
+			assert.NoError(t, j.SwapSet(journalUpdates("old")))
+
+			crashAfterRenames(base, test.renames)
+			assert.ErrorIs(t, j.SwapSet(journalUpdates("new")), errCrash)
+
+			// a restarted process rolls a journaled commit forward and others back
+			restarted, err := NewJournaledFileSystem(NewFileSystemBase(root))
+			assert.NoError(t, err)
+			assertJournalValues(t, restarted, test.want)
+			assertJournalClean(t, root)
+		})
+	}
+}
+
+func TestJournalReplayBeforeNextCommit(t *testing.T) {
+	root := t.TempDir()
+	base := NewFileSystemBase(root)
+	assert.NoError(t, base.MkDir("dir"))
+	j, err := NewJournaledFileSystem(base)
+	assert.NoError(t, err)
+	crashAfterRenames(base, 2)
+	assert.ErrorIs(t, j.SwapSet(journalUpdates("first")), errCrash)
+
+	// the same instance finishes the failed commit before starting the next one
+	base.replaceFile = nil
+	assert.NoError(t, j.SwapSet(map[FSName]io.ReadSeeker{"a": strings.NewReader("second")}))
+	value, err := j.GetString("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "second", value)
+	for _, name := range []FSName{"b", "dir/c"} {
+		value, err := j.GetString(name)
+		assert.NoError(t, err)
+		assert.Equal(t, "first", value)
+	}
+	assertJournalClean(t, root)
+}
+
+func TestJournalCrashBeforeJournal(t *testing.T) {
+	root := t.TempDir()
+	base := NewFileSystemBase(root)
+	assert.NoError(t, base.MkDir("dir"))
+	j, err := NewJournaledFileSystem(base)
+	assert.NoError(t, err)
+	assert.NoError(t, j.SwapSet(journalUpdates("old")))
+	// a temp file written before the crash, but never recorded in a journal
+	_, err = base.writeTempFile("a", strings.NewReader("new"), -1)
+	assert.NoError(t, err)
+
+	restarted, err := NewJournaledFileSystem(NewFileSystemBase(root))
+	assert.NoError(t, err)
+	assertJournalValues(t, restarted, "old")
+}
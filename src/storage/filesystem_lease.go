@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var ErrLeaseHeld = errors.New("lease held by another writer")
+
+type lease struct {
+	id      string
+	holder  string
+	expires time.Time
+}
+
+// Gives cooperative single-writer locking per name. While a name is leased, only writes
+// that present the lease ID through SetStringWithLease or SetFileWithLease succeed, all
+// other writes and removals fail with ErrLeaseHeld. Leases are kept in memory and expire
+// after their TTL, so a crashed holder cannot block a name for good.
+type LeaseFileSystem struct {
+	FileSystem
+	mu     sync.Mutex
+	leases map[FSName]*lease
+	// held per name during writes, so a lease cannot start mid-write
+	names nameLocks
+}
+
+func NewLeaseFileSystem(fs FileSystem) *LeaseFileSystem {
+	return &LeaseFileSystem{FileSystem: fs, leases: map[FSName]*lease{}}
+}
+
+// Leases name to holder for ttl and returns the lease ID. A holder that already has the
+// lease gets its TTL renewed and keeps the same ID.
+func (l *LeaseFileSystem) AcquireLease(name FSName, holder string, ttl time.Duration) (string, error) {
+	defer l.names.lock(string(name))()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if current := l.leases[name]; current != nil && now.Before(current.expires) {
+		if current.holder != holder {
+			return "", errors.WithMessagef(ErrLeaseHeld, "%s by %s", name, current.holder)
+		}
+		current.expires = now.Add(ttl)
+		return current.id, nil
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	l.leases[name] = &lease{id: hex.EncodeToString(id), holder: holder, expires: now.Add(ttl)}
+	return l.leases[name].id, nil
+}
+
+// Frees the lease before it expires. Releasing an expired or unknown lease is a no-op.
+func (l *LeaseFileSystem) ReleaseLease(name FSName, leaseID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.checkLocked(name, leaseID); err != nil {
+		return err
+	}
+	delete(l.leases, name)
+	return nil
+}
+
+// Must be called with the lock held.
+func (l *LeaseFileSystem) checkLocked(name FSName, leaseID string) error {
+	current := l.leases[name]
+	if current == nil {
+		return nil
+	}
+	if !time.Now().Before(current.expires) {
+		delete(l.leases, name)
+		return nil
+	}
+	if current.id != leaseID {
+		return errors.WithMessagef(ErrLeaseHeld, "%s by %s", name, current.holder)
+	}
+	return nil
+}
+
+// Runs fn if leaseID may write name. Only writes to the same name and new leases on it
+// wait for fn, so the lease cannot change hands mid-write.
+func (l *LeaseFileSystem) withLease(name FSName, leaseID string, fn func() error) error {
+	defer l.names.lock(string(name))()
+	l.mu.Lock()
+	err := l.checkLocked(name, leaseID)
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return fn()
+}
+
+func (l *LeaseFileSystem) SetStringWithLease(name FSName, leaseID string, value string) error {
+	return l.withLease(name, leaseID, func() error {
+		return l.FileSystem.SetString(name, value)
+	})
+}
+
+func (l *LeaseFileSystem) SetFileWithLease(name FSName, leaseID string, value io.Reader) error {
+	return l.withLease(name, leaseID, func() error {
+		return l.FileSystem.SetFile(name, value)
+	})
+}
+
+// Fails with ErrLeaseHeld if name is leased.
+func (l *LeaseFileSystem) SetString(name FSName, value string) error {
+	return l.SetStringWithLease(name, "", value)
+}
+
+// Fails with ErrLeaseHeld if name is leased.
+func (l *LeaseFileSystem) SetFile(name FSName, value io.Reader) error {
+	return l.SetFileWithLease(name, "", value)
+}
+
+// Fails with ErrLeaseHeld if name is leased.
+func (l *LeaseFileSystem) RemoveFile(name FSName) error {
+	return l.withLease(name, "", func() error {
+		return l.FileSystem.RemoveFile(name)
+	})
+}
+
+func (l *LeaseFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(l, name)
+}
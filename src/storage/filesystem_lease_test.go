@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeaseWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		leaseID func(leaseID string) string
+		err     error
+	}{
+		{"holder", func(leaseID string) string { return leaseID }, nil},
+		{"no lease id", func(string) string { return "" }, ErrLeaseHeld},
+		{"wrong lease id", func(string) string { return "other" }, ErrLeaseHeld},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := NewLeaseFileSystem(NewFileSystemBase(t.TempDir()))
+			leaseID, err := fs.AcquireLease("file", "holder", time.Hour)
+			assert.NoError(t, err)
+			err = fs.SetStringWithLease("file", test.leaseID(leaseID), "value")
+			if test.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestLeaseBlocksOtherHolders(t *testing.T) {
+	fs := NewLeaseFileSystem(NewFileSystemBase(t.TempDir()))
+	leaseID, err := fs.AcquireLease("file", "a", time.Hour)
+	assert.NoError(t, err)
+	_, err = fs.AcquireLease("file", "b", time.Hour)
+	assert.ErrorIs(t, err, ErrLeaseHeld)
+	assert.ErrorIs(t, fs.SetString("file", "value"), ErrLeaseHeld)
+	assert.ErrorIs(t, fs.RemoveFile("file"), ErrLeaseHeld)
+	// other names are not affected
+	assert.NoError(t, fs.SetString("other", "value"))
+	renewed, err := fs.AcquireLease("file", "a", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, leaseID, renewed)
+	assert.NoError(t, fs.ReleaseLease("file", leaseID))
+	assert.NoError(t, fs.SetString("file", "value"))
+}
+
+func TestLeaseExpires(t *testing.T) {
+	fs := NewLeaseFileSystem(NewFileSystemBase(t.TempDir()))
+	_, err := fs.AcquireLease("file", "a", time.Millisecond)
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, fs.SetString("file", "value"))
+	_, err = fs.AcquireLease("file", "b", time.Hour)
+	assert.NoError(t, err)
+}
+
+// Blocks reads until released.
+type blockingReader struct {
+	io.Reader
+	release chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	<-r.release
+	return r.Reader.Read(p)
+}
+
+func TestLeaseSlowWriteDoesNotBlockOthers(t *testing.T) {
+	fs := NewLeaseFileSystem(NewFileSystemBase(t.TempDir()))
+	slow := &blockingReader{Reader: strings.NewReader("value"), release: make(chan struct{})}
+	done := make(chan error)
+	go func() {
+		done <- fs.SetFile("slow", slow)
+	}()
+	// give the slow write time to start
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, fs.SetString("other", "value"))
+	_, err := fs.AcquireLease("other", "a", time.Hour)
+	assert.NoError(t, err)
+	close(slow.release)
+	assert.NoError(t, <-done)
+}
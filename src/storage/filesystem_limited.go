@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Caps the number of concurrently open file handles for reads. GetFile blocks until a slot is
+// free, and the slot is released when the returned file is closed. GetString and GetBytes hold
+// a slot while reading. Writes are not limited.
+type LimitedHandlesFileSystem struct {
+	FileSystem
+	// nil if unlimited
+	slots chan struct{}
+}
+
+// A maxHandles of zero or less means no limit.
+func NewLimitedHandlesFileSystem(fs FileSystem, maxHandles int) *LimitedHandlesFileSystem {
+	l := &LimitedHandlesFileSystem{FileSystem: fs}
+	if maxHandles > 0 {
+		l.slots = make(chan struct{}, maxHandles)
+	}
+	return l
+}
+
+func (l *LimitedHandlesFileSystem) GetString(name FSName) (string, error) {
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return l.FileSystem.GetString(name)
+}
+
+func (l *LimitedHandlesFileSystem) GetBytes(name FSName) ([]byte, error) {
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.FileSystem.GetBytes(name)
+}
+
+func (l *LimitedHandlesFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	return l.GetFileContext(context.Background(), name)
+}
+
+// Like GetFile, but gives up waiting for a free slot when ctx is done.
+func (l *LimitedHandlesFileSystem) GetFileContext(ctx context.Context, name FSName) (ReadonlyFile, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	file, err := l.FileSystem.GetFile(name)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &limitedFile{ReadonlyFile: file, release: release}, nil
+}
+
+func (l *LimitedHandlesFileSystem) GetSeekableFile(name FSName) (io.ReadSeekCloser, int64, error) {
+	return l.GetSeekableFileContext(context.Background(), name)
+}
+
+// Like GetSeekableFile, but gives up waiting for a free slot when ctx is done.
+func (l *LimitedHandlesFileSystem) GetSeekableFileContext(ctx context.Context, name FSName) (io.ReadSeekCloser, int64, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, size, err := l.FileSystem.GetSeekableFile(name)
+	if err != nil {
+		release()
+		return nil, 0, err
+	}
+	return &limitedSeekableFile{ReadSeekCloser: file, release: release}, size, nil
+}
+
+// Holds a slot while streaming.
+func (l *LimitedHandlesFileSystem) WriteTo(name FSName, w io.Writer, offset int64, length int64) (int64, error) {
+	return writeFileTo(l, name, w, offset, length)
+}
+
+func (l *LimitedHandlesFileSystem) StreamArchive(prefix FSName, format ArchiveFormat, w io.Writer) error {
+	return streamArchive(l, prefix, format, w)
+}
+
+// Returns a func that frees the acquired slot. It is safe to call more than once.
+func (l *LimitedHandlesFileSystem) acquire(ctx context.Context) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-l.slots })
+	}, nil
+}
+
+type limitedFile struct {
+	ReadonlyFile
+	release func()
+}
+
+func (f *limitedFile) Close() error {
+	defer f.release()
+	return f.ReadonlyFile.Close()
+}
+
+type limitedSeekableFile struct {
+	io.ReadSeekCloser
+	release func()
+}
+
+func (f *limitedSeekableFile) Close() error {
+	defer f.release()
+	return f.ReadSeekCloser.Close()
+}
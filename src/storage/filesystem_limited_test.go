@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestLimitedHandlesFileSystem(t *testing.T, maxHandles int) *LimitedHandlesFileSystem {
+	l := NewLimitedHandlesFileSystem(NewFileSystemBase(t.TempDir()), maxHandles)
+	assert.NoError(t, l.SetString("file", "value"))
+	return l
+}
+
+func TestLimitedHandlesUnlimited(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxHandles int
+	}{
+		{"zero", 0},
+		{"negative", -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLimitedHandlesFileSystem(t, test.maxHandles)
+			var files []ReadonlyFile
+			for i := 0; i < 5; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				file, err := l.GetFileContext(ctx, "file")
+				cancel()
+				assert.NoError(t, err)
+				files = append(files, file)
+			}
+			for _, file := range files {
+				assert.NoError(t, file.Close())
+			}
+		})
+	}
+}
+
+func TestLimitedHandlesBlocksUntilClosed(t *testing.T) {
+	l := newTestLimitedHandlesFileSystem(t, 1)
+	file, err := l.GetFile("file")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = l.GetFileContext(ctx, "file")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, _, err = l.GetSeekableFileContext(ctx, "file")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.NoError(t, file.Close())
+	// closing again must not release another slot
+	_ = file.Close()
+	file, err = l.GetFile("file")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+}
+
+func TestLimitedHandlesWholeFileReads(t *testing.T) {
+	tests := []struct {
+		name string
+		read func(l *LimitedHandlesFileSystem) (string, error)
+	}{
+		{"string", func(l *LimitedHandlesFileSystem) (string, error) {
+			return l.GetString("file")
+		}},
+		{"bytes", func(l *LimitedHandlesFileSystem) (string, error) {
+			data, err := l.GetBytes("file")
+			return string(data), err
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLimitedHandlesFileSystem(t, 1)
+			file, err := l.GetFile("file")
+			assert.NoError(t, err)
+			done := make(chan string)
+			go func() {
+				value, err := test.read(l)
+				assert.NoError(t, err)
+				done <- value
+			}()
+			select {
+			case <-done:
+				t.Fatal("read did not wait for a free slot")
+			case <-time.After(50 * time.Millisecond):
+			}
+			assert.NoError(t, file.Close())
+			assert.Equal(t, "value", <-done)
+		})
+	}
+}
+
+func TestLimitedHandlesNotFoundReleasesSlot(t *testing.T) {
+	l := newTestLimitedHandlesFileSystem(t, 1)
+	for i := 0; i < 3; i++ {
+		_, err := l.GetFile("missing")
+		assert.True(t, isNotFound(err))
+		_, err = l.GetString("missing")
+		assert.True(t, isNotFound(err))
+	}
+	file, err := l.GetFile("file")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+)
+
+var ErrFileTooLarge = errors.New("file too large")
+
+const defaultMaxReadBytes = 64 << 20
+
+// Sets the largest file GetBytes reads into memory. Zero or less disables the limit.
+func WithMaxReadBytes(maxBytes int64) FileSystemOption {
+	return func(a *FileSystemBase) {
+		if maxBytes <= 0 {
+			maxBytes = -1
+		}
+		a.maxReadBytes = maxBytes
+	}
+}
+
+func (a *FileSystemBase) maxReadBytesOrDefault() int64 {
+	if a.maxReadBytes == 0 {
+		return defaultMaxReadBytes
+	}
+	return a.maxReadBytes
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"io"
+	"os"
+	"time"
+)
+
+// Logs every operation at debug level with its name and duration, retries and fallbacks
+// at warn level, and failures at error level. Files that are not found and ClaimOne finding
+// no work are logged at debug level, since lookups like Exists expect them. Without a logger,
+// nothing is logged. To log the operations of decorators as well, wrap them with
+// NewLoggingFileSystem.
+func WithLogger(logger *zerolog.Logger) FileSystemOption {
+	return func(a *FileSystemBase) {
+		a.logger = logger
+	}
+}
+
+// Leaves resolved paths out of the log messages, since they may reveal the storage layout.
+func WithRedactedPaths() FileSystemOption {
+	return func(a *FileSystemBase) {
+		a.redactPaths = true
+	}
+}
+
+var noopTrace = func(*error) {}
+
+// Starts timing op, the returned func logs its outcome. Use as defer a.trace(op, name)(&err).
+func (a *FileSystemBase) trace(op string, name FSName) func(*error) {
+	if a.redactPaths {
+		return traceOp(a.logger, op, name, nil)
+	}
+	return traceOp(a.logger, op, name, a.resolvePath)
+}
+
+// Like FileSystemBase.trace, but logs the resolved path only if resolvePath is not nil.
+func traceOp(logger *zerolog.Logger, op string, name FSName, resolvePath func(FSName) string) func(*error) {
+	if logger == nil {
+		return noopTrace
+	}
+	start := time.Now()
+	return func(errPtr *error) {
+		var event *zerolog.Event
+		if isNotFound(*errPtr) || errors.Is(*errPtr, ErrNoWork) {
+			event = logger.Debug().Err(*errPtr)
+		} else if *errPtr != nil {
+			event = logger.Error().Err(*errPtr)
+		} else {
+			event = logger.Debug()
+		}
+		event = event.Str("op", op).Str("name", string(name)).Dur("duration", time.Since(start))
+		if resolvePath != nil {
+			event = event.Str("path", resolvePath(name))
+		}
+		event.Msg("storage")
+	}
+}
+
+func (a *FileSystemBase) warn(err error, msg string) {
+	if a.logger == nil {
+		return
+	}
+	a.logger.Warn().Err(err).Msg(msg)
+}
+
+// Logs the operations of any FileSystem, such as a stack of decorators, like WithLogger does
+// for FileSystemBase. Since decorators have no paths of their own, only names are logged.
+type LoggingFileSystem struct {
+	fs     FileSystem
+	logger *zerolog.Logger
+}
+
+func NewLoggingFileSystem(fs FileSystem, logger *zerolog.Logger) *LoggingFileSystem {
+	return &LoggingFileSystem{fs: fs, logger: logger}
+}
+
+func (l *LoggingFileSystem) trace(op string, name FSName) func(*error) {
+	return traceOp(l.logger, op, name, nil)
+}
+
+func (l *LoggingFileSystem) GetString(name FSName) (_ string, err error) {
+	defer l.trace("get string", name)(&err)
+	return l.fs.GetString(name)
+}
+
+func (l *LoggingFileSystem) GetBytes(name FSName) (_ []byte, err error) {
+	defer l.trace("get bytes", name)(&err)
+	return l.fs.GetBytes(name)
+}
+
+func (l *LoggingFileSystem) GetFile(name FSName) (_ ReadonlyFile, err error) {
+	defer l.trace("get file", name)(&err)
+	return l.fs.GetFile(name)
+}
+
+func (l *LoggingFileSystem) GetSeekableFile(name FSName) (_ io.ReadSeekCloser, _ int64, err error) {
+	defer l.trace("get seekable file", name)(&err)
+	return l.fs.GetSeekableFile(name)
+}
+
+func (l *LoggingFileSystem) SetString(name FSName, value string) (err error) {
+	defer l.trace("set string", name)(&err)
+	return l.fs.SetString(name, value)
+}
+
+func (l *LoggingFileSystem) SetFile(name FSName, value io.Reader) (err error) {
+	defer l.trace("set file", name)(&err)
+	return l.fs.SetFile(name, value)
+}
+
+func (l *LoggingFileSystem) RemoveFile(name FSName) (err error) {
+	defer l.trace("remove file", name)(&err)
+	return l.fs.RemoveFile(name)
+}
+
+func (l *LoggingFileSystem) RemoveFileIfExists(name FSName) (err error) {
+	defer l.trace("remove file if exists", name)(&err)
+	return l.fs.RemoveFileIfExists(name)
+}
+
+func (l *LoggingFileSystem) Stat(name FSName) (_ os.FileInfo, err error) {
+	defer l.trace("stat", name)(&err)
+	return l.fs.Stat(name)
+}
+
+func (l *LoggingFileSystem) MkDir(name FSName) (err error) {
+	defer l.trace("mkdir", name)(&err)
+	return l.fs.MkDir(name)
+}
+
+func (l *LoggingFileSystem) ReadDir(name FSName) (_ []os.DirEntry, err error) {
+	defer l.trace("read dir", name)(&err)
+	return l.fs.ReadDir(name)
+}
+
+func (l *LoggingFileSystem) ListFiles(prefix FSName) (_ []FSName, err error) {
+	defer l.trace("list files", prefix)(&err)
+	return l.fs.ListFiles(prefix)
+}
+
+func (l *LoggingFileSystem) ListModifiedSince(prefix FSName, since time.Time) (_ []FSName, err error) {
+	defer l.trace("list modified since", prefix)(&err)
+	return l.fs.ListModifiedSince(prefix, since)
+}
+
+func (l *LoggingFileSystem) TreeHash(prefix FSName) (_ string, err error) {
+	defer l.trace("tree hash", prefix)(&err)
+	return l.fs.TreeHash(prefix)
+}
+
+func (l *LoggingFileSystem) WriteTo(name FSName, w io.Writer, offset int64, length int64) (_ int64, err error) {
+	defer l.trace("write to", name)(&err)
+	return l.fs.WriteTo(name, w, offset, length)
+}
+
+func (l *LoggingFileSystem) StreamArchive(prefix FSName, format ArchiveFormat, w io.Writer) (err error) {
+	defer l.trace("stream archive", prefix)(&err)
+	return l.fs.StreamArchive(prefix, format, w)
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+type logEntry struct {
+	Level string `json:"level"`
+	Op    string `json:"op"`
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+func parseLogEntries(t *testing.T, buffer *bytes.Buffer) []logEntry {
+	var entries []logEntry
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry logEntry
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLoggerLevels(t *testing.T) {
+	tests := []struct {
+		name  string
+		op    string
+		run   func(fs *FileSystemBase) error
+		level string
+	}{
+		{"success", "set file", func(fs *FileSystemBase) error {
+			return fs.SetString("file", "value")
+		}, "debug"},
+		{"not found", "get string", func(fs *FileSystemBase) error {
+			_, err := fs.GetString("missing")
+			return err
+		}, "debug"},
+		{"modified since", "list modified since", func(fs *FileSystemBase) error {
+			_, err := fs.ListModifiedSince("", time.Time{})
+			return err
+		}, "debug"},
+		{"no work", "claim one", func(fs *FileSystemBase) error {
+			_, _, err := fs.ClaimOne("pending", "claimed")
+			return err
+		}, "debug"},
+		{"failure", "get bytes", func(fs *FileSystemBase) error {
+			if err := fs.MkDir("dir"); err != nil {
+				return err
+			}
+			_, err := fs.GetBytes("dir")
+			return err
+		}, "error"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			logger := zerolog.New(&buffer).Level(zerolog.DebugLevel)
+			fs := NewFileSystemBase(t.TempDir(), WithLogger(&logger))
+			_ = test.run(fs)
+			var found bool
+			for _, entry := range parseLogEntries(t, &buffer) {
+				if entry.Op == test.op {
+					found = true
+					assert.Equal(t, test.level, entry.Level)
+					assert.NotEmpty(t, entry.Path)
+				}
+			}
+			assert.True(t, found, "no log entry for %s", test.op)
+		})
+	}
+}
+
+func TestLoggerRedactedPaths(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := zerolog.New(&buffer).Level(zerolog.DebugLevel)
+	fs := NewFileSystemBase(t.TempDir(), WithLogger(&logger), WithRedactedPaths())
+	assert.NoError(t, fs.SetString("file", "value"))
+	entries := parseLogEntries(t, &buffer)
+	assert.NotEmpty(t, entries)
+	for _, entry := range entries {
+		assert.Empty(t, entry.Path)
+		assert.Equal(t, "file", entry.Name)
+	}
+}
+
+func TestLoggingFileSystem(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := zerolog.New(&buffer).Level(zerolog.DebugLevel)
+	fs := NewLoggingFileSystem(NewTTLFileSystem(NewFileSystemBase(t.TempDir())), &logger)
+	assert.NoError(t, fs.MkDir("dir"))
+	assert.NoError(t, fs.SetString("dir/file", "value"))
+	_, err := fs.GetString("missing")
+	assert.True(t, isNotFound(err))
+	// the dir is not empty
+	assert.Error(t, fs.RemoveFile("dir"))
+
+	entries := parseLogEntries(t, &buffer)
+	assert.Len(t, entries, 4)
+	ops := []string{"mkdir", "set string", "get string", "remove file"}
+	levels := []string{"debug", "debug", "debug", "error"}
+	for i, entry := range entries {
+		assert.Equal(t, ops[i], entry.Op)
+		assert.Equal(t, levels[i], entry.Level)
+		assert.Empty(t, entry.Path)
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	logWriterBufferSize   = 64 << 10
+	logWriterSyncInterval = time.Second
+)
+
+// Opens name for appending, creating it if needed, and returns a buffered writer that is
+// safe for concurrent use. Every Write is appended as a whole, so lines written with a single
+// call never interleave. Buffered data is written and synced to disk every second and whenever
+// the buffer fills up, so at most the last second is lost on a crash. Close flushes and syncs
+// everything that is left.
+func (a *FileSystemBase) LogWriter(name FSName) (_ io.WriteCloser, err error) {
+	defer a.trace("log writer", name)(&err)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	f, err := os.OpenFile(a.resolveWritePath(name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w := &logWriter{
+		file:   f,
+		buffer: bufio.NewWriterSize(f, logWriterBufferSize),
+		done:   make(chan struct{}),
+	}
+	go w.syncPeriodically()
+	return w, nil
+}
+
+type logWriter struct {
+	mu     sync.Mutex
+	file   *os.File
+	buffer *bufio.Writer
+	// the first failure of a background sync, returned by the next Write or Close
+	err    error
+	closed bool
+	done   chan struct{}
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if len(p) > w.buffer.Available() && w.buffer.Buffered() > 0 {
+		if err := w.sync(); err != nil {
+			return 0, err
+		}
+	}
+	return w.buffer.Write(p)
+}
+
+// Must be called with the lock held.
+func (w *logWriter) sync() error {
+	if w.buffer.Buffered() == 0 {
+		return nil
+	}
+	if err := w.buffer.Flush(); err != nil {
+		return errors.WithMessage(err, "flush log")
+	}
+	if err := w.file.Sync(); err != nil {
+		return errors.WithMessage(err, "sync log")
+	}
+	return nil
+}
+
+func (w *logWriter) syncPeriodically() {
+	ticker := time.NewTicker(logWriterSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = w.sync()
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *logWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+	close(w.done)
+	err := w.err
+	if err == nil {
+		err = w.sync()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
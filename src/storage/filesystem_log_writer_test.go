@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogWriterConcurrentWrites(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, fs.SetFile("log", strings.NewReader("existing\n")))
+	writer, err := fs.LogWriter("log")
+	assert.NoError(t, err)
+	const writers, lines = 8, 500
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < lines; j++ {
+				// long enough that lines spill over the buffer mid-run
+				line := fmt.Sprintf("writer %d line %d %s\n", i, j, strings.Repeat("x", 100))
+				_, err := writer.Write([]byte(line))
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, writer.Close())
+
+	data, err := fs.GetBytes("log")
+	assert.NoError(t, err)
+	written := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	assert.Equal(t, "existing", written[0])
+	next := make([]int, writers)
+	for _, line := range written[1:] {
+		var i, j int
+		var rest string
+		_, err := fmt.Sscanf(line, "writer %d line %d %s", &i, &j, &rest)
+		assert.NoError(t, err, line)
+		assert.Equal(t, strings.Repeat("x", 100), rest, line)
+		// each writer's lines are in order
+		assert.Equal(t, next[i], j, line)
+		next[i]++
+	}
+	for i := range next {
+		assert.Equal(t, lines, next[i])
+	}
+}
+
+func TestLogWriterFlushes(t *testing.T) {
+	root := t.TempDir()
+	fs := NewFileSystemBase(root)
+	writer, err := fs.LogWriter("log")
+	assert.NoError(t, err)
+	stored := func() string {
+		data, err := os.ReadFile(fs.resolvePath("log"))
+		assert.NoError(t, err)
+		return string(data)
+	}
+
+	first := strings.Repeat("a", logWriterBufferSize-1)
+	_, err = writer.Write([]byte(first))
+	assert.NoError(t, err)
+	assert.Empty(t, stored())
+	// a write that does not fit flushes the buffer first
+	_, err = writer.Write([]byte("bb"))
+	assert.NoError(t, err)
+	assert.Equal(t, first, stored())
+
+	// buffered data is synced in the background
+	assert.Eventually(t, func() bool {
+		return stored() == first+"bb"
+	}, 3*logWriterSyncInterval, 10*time.Millisecond)
+
+	_, err = writer.Write([]byte("cc"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	assert.Equal(t, first+"bbcc", stored())
+	_, err = writer.Write([]byte("dd"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+	assert.ErrorIs(t, writer.Close(), os.ErrClosed)
+}
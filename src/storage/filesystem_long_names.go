@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Most filesystems limit each path component to 255 bytes.
+const maxNameLength = 255
+
+// Components are hashed well below the limit, since temp files add a prefix and suffix to
+// the name of their target, see tempFilePattern and NewSequentialTempNamer.
+const longNameThreshold = maxNameLength - 32
+
+const (
+	hashedNamePrefix = "~"
+	longNamesDir     = ".longnames"
+)
+
+// Replaces on-disk path components that are too long for the filesystem, including the
+// temp file suffix, with a deterministic hash, instead of failing with ENAMETOOLONG. The
+// original component is recorded in a hidden dir at the root when a file or dir is created,
+// so ListFiles still returns the original FSNames, and the record of a file is removed along
+// with it. Should be applied after options that change the on-disk names, such as
+// WithNameSanitizer, so the limit is checked against the final names.
+func WithLongNameHashing() FileSystemOption {
+	return func(a *FileSystemBase) {
+		resolvePath := a.resolvePath
+		root := resolvePath("")
+		a.longNames = &longNames{root: root, resolvePath: resolvePath}
+		a.resolvePath = func(name FSName) string {
+			resolved, _ := a.longNames.hash(name)
+			return resolved
+		}
+		decodeName := a.decodeName
+		a.decodeName = func(component string) (string, error) {
+			if isHashedName(component) {
+				original, err := os.ReadFile(filepath.Join(root, longNamesDir, component))
+				if err != nil {
+					return "", errors.WithMessage(err, "get long name")
+				}
+				component = string(original)
+			}
+			if decodeName == nil {
+				return component, nil
+			}
+			return decodeName(component)
+		}
+	}
+}
+
+type longNames struct {
+	root string
+	// resolves names before hashing
+	resolvePath func(FSName) string
+}
+
+type longNameRecord struct {
+	hashed   string
+	original string
+}
+
+// The hash covers the path up to and including the component, so every record belongs to
+// exactly one file or dir and can be removed with it.
+func hashName(relPath string) string {
+	digest := sha256.Sum256([]byte(relPath))
+	return hashedNamePrefix + hex.EncodeToString(digest[:])
+}
+
+func isHashedName(component string) bool {
+	if len(component) != len(hashedNamePrefix)+sha256.Size*2 || !strings.HasPrefix(component, hashedNamePrefix) {
+		return false
+	}
+	_, err := hex.DecodeString(component[len(hashedNamePrefix):])
+	return err == nil
+}
+
+// Returns the on-disk path of name and the records of its hashed components, in order.
+func (l *longNames) hash(name FSName) (string, []longNameRecord) {
+	resolved := l.resolvePath(name)
+	relPath, err := filepath.Rel(l.root, resolved)
+	if err != nil || relPath == "." {
+		return resolved, nil
+	}
+	components := strings.Split(relPath, string(filepath.Separator))
+	hashed := make([]string, len(components))
+	var records []longNameRecord
+	for i, component := range components {
+		hashed[i] = component
+		if len(component) <= longNameThreshold {
+			continue
+		}
+		hashed[i] = hashName(strings.Join(components[:i+1], "/"))
+		records = append(records, longNameRecord{hashed: hashed[i], original: component})
+	}
+	if len(records) == 0 {
+		return resolved, nil
+	}
+	return filepath.Join(append([]string{l.root}, hashed...)...), records
+}
+
+// Like resolvePath, but for a name that is about to be created. Records the original names
+// of its hashed components first, so ListFiles can decode them.
+func (a *FileSystemBase) resolveWritePath(name FSName) string {
+	if a.longNames == nil {
+		return a.resolvePath(name)
+	}
+	resolved, records := a.longNames.hash(name)
+	for _, record := range records {
+		if err := a.longNames.record(record); err != nil {
+			// only ListFiles needs the record, so the file itself stays accessible
+			a.warn(err, "failed to record long name")
+		}
+	}
+	return resolved
+}
+
+// Removes the record of the last component of the removed file name, if it was hashed.
+func (a *FileSystemBase) forgetLongName(name FSName) {
+	if a.longNames == nil {
+		return
+	}
+	resolved, records := a.longNames.hash(name)
+	if len(records) == 0 || records[len(records)-1].hashed != filepath.Base(resolved) {
+		return
+	}
+	recordPath := filepath.Join(a.longNames.root, longNamesDir, records[len(records)-1].hashed)
+	if err := os.Remove(recordPath); err != nil && !os.IsNotExist(err) {
+		a.warn(err, "failed to remove long name record")
+	}
+}
+
+func (l *longNames) record(record longNameRecord) error {
+	recordPath := filepath.Join(l.root, longNamesDir, record.hashed)
+	if _, err := os.Stat(recordPath); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(recordPath), 0700); err != nil {
+		return err
+	}
+	// written to a temp file first, so a concurrent decode never sees a partial record
+	f, err := os.CreateTemp(filepath.Dir(recordPath), tempFilePattern(record.hashed))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(record.original); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), recordPath)
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrLayoutConflict = errors.New("file exists in both layouts with different contents")
+
+// Moves every file of a flat layout, where all files are stored directly in the dir that
+// from resolves the empty name to, to the path that to resolves its name to, e.g. a sharded
+// location. Intermediate dirs are created as needed. The write lock is held for one file at
+// a time, so the store stays usable during the migration. Files that already exist at their
+// new location with the same contents only have their old copy removed, so an interrupted
+// migration can simply be run again. If the contents differ, both copies are kept and the
+// file fails with ErrLayoutConflict, returned together with other conflicts as a *BatchError
+// once the rest is migrated. Returns the number of moved files.
+func (a *FileSystemBase) MigrateLayout(from func(FSName) string, to func(FSName) string) (int, error) {
+	a.mu.RLock()
+	entries, err := os.ReadDir(from(""))
+	a.mu.RUnlock()
+	if err != nil {
+		return 0, errors.WithMessage(err, "read flat dir")
+	}
+	moved := 0
+	batchErr := &BatchError{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		name, err := a.decodePath(entry.Name())
+		if err != nil {
+			return moved, errors.WithMessagef(err, "decode %s", entry.Name())
+		}
+		ok, err := a.migrateFile(from(FSName(name)), to(FSName(name)))
+		if errors.Is(err, ErrLayoutConflict) {
+			batchErr.Failures = append(batchErr.Failures, &BatchFailure{Name: FSName(name), Err: err})
+			continue
+		} else if err != nil {
+			return moved, errors.WithMessagef(err, "migrate %s", name)
+		}
+		if ok {
+			moved++
+		}
+	}
+	if len(batchErr.Failures) > 0 {
+		batchErr.Succeeded = moved
+		return moved, batchErr
+	}
+	return moved, nil
+}
+
+func (a *FileSystemBase) migrateFile(oldPath string, newPath string) (bool, error) {
+	if oldPath == newPath {
+		return false, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := os.Stat(newPath); err == nil {
+		return false, a.removeMigratedCopy(oldPath, newPath)
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0700); err != nil {
+		return false, errors.WithMessage(err, "create dir")
+	}
+	if err := os.Rename(oldPath, newPath); os.IsNotExist(err) {
+		// removed since reading the dir
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Removes the old copy of a file that was already migrated, unless its contents differ from
+// the new one. Must be called with the write lock held.
+func (a *FileSystemBase) removeMigratedCopy(oldPath string, newPath string) error {
+	same, err := sameFileContents(oldPath, newPath)
+	if os.IsNotExist(err) {
+		// removed since reading the dir
+		return nil
+	} else if err != nil {
+		return errors.WithMessage(err, "compare copies")
+	}
+	if !same {
+		return ErrLayoutConflict
+	}
+	if err := os.Remove(oldPath); err != nil && !os.IsNotExist(err) {
+		return errors.WithMessage(err, "remove old copy")
+	}
+	return nil
+}
+
+func sameFileContents(first string, second string) (bool, error) {
+	files := make([]*os.File, 2)
+	sizes := make([]int64, 2)
+	for i, filePath := range []string{first, second} {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			return false, err
+		}
+		files[i], sizes[i] = f, stat.Size()
+	}
+	if sizes[0] != sizes[1] {
+		return false, nil
+	}
+	buffers := [2][]byte{make([]byte, defaultCopyBufferSize), make([]byte, defaultCopyBufferSize)}
+	for {
+		n, err := io.ReadFull(files[0], buffers[0])
+		if err == io.EOF {
+			return true, nil
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return false, err
+		}
+		if _, err := io.ReadFull(files[1], buffers[1][:n]); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(buffers[0][:n], buffers[1][:n]) {
+			return false, nil
+		}
+	}
+}
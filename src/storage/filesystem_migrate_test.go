@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Like the flat layout, but with files sharded by the first letter of their name.
+func shardedPath(root string) func(FSName) string {
+	return func(name FSName) string {
+		if name == "" {
+			return filepath.Join(root, "sharded")
+		}
+		return filepath.Join(root, "sharded", string(name[:1]), string(name))
+	}
+}
+
+func flatPath(root string) func(FSName) string {
+	return func(name FSName) string {
+		return filepath.Join(root, string(name))
+	}
+}
+
+func TestMigrateLayout(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  map[string]string
+		want      map[string]string
+		moved     int
+		conflicts []FSName
+	}{
+		{"empty", nil, map[string]string{}, 0, nil},
+		{"flat files", map[string]string{"apple": "1", "avocado": "2", "banana": "3"}, map[string]string{
+			"sharded/a/apple":   "1",
+			"sharded/a/avocado": "2",
+			"sharded/b/banana":  "3",
+		}, 3, nil},
+		{"already migrated", map[string]string{"apple": "1", "sharded/a/apple": "1", "banana": "2"}, map[string]string{
+			"sharded/a/apple":  "1",
+			"sharded/b/banana": "2",
+		}, 1, nil},
+		{"conflict", map[string]string{"apple": "new", "sharded/a/apple": "old", "avocado": "1", "banana": "2"}, map[string]string{
+			"apple":             "new",
+			"sharded/a/apple":   "old",
+			"sharded/a/avocado": "1",
+			"sharded/b/banana":  "2",
+		}, 2, []FSName{"apple"}},
+		{"conflict of same size", map[string]string{"apple": "abc", "sharded/a/apple": "abd"}, map[string]string{
+			"apple":           "abc",
+			"sharded/a/apple": "abd",
+		}, 0, []FSName{"apple"}},
+		{"hidden files", map[string]string{".hidden": "1", ".dir/apple": "2"}, map[string]string{
+			".hidden":    "1",
+			".dir/apple": "2",
+		}, 0, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			fs := NewFileSystemBase(root)
+			for name, value := range test.existing {
+				writeTestFile(t, filepath.Join(root, name), value)
+			}
+			assertConflicts := func(err error) {
+				if test.conflicts == nil {
+					assert.NoError(t, err)
+					return
+				}
+				var batchErr *BatchError
+				assert.ErrorAs(t, err, &batchErr)
+				var names []FSName
+				for _, failure := range batchErr.Failures {
+					names = append(names, failure.Name)
+				}
+				assert.Equal(t, test.conflicts, names)
+				assert.ErrorIs(t, err, ErrLayoutConflict)
+			}
+			moved, err := fs.MigrateLayout(flatPath(root), shardedPath(root))
+			assertConflicts(err)
+			assert.Equal(t, test.moved, moved)
+			assert.Equal(t, test.want, readTestTree(t, root))
+
+			// running again is a no-op
+			moved, err = fs.MigrateLayout(flatPath(root), shardedPath(root))
+			assertConflicts(err)
+			assert.Equal(t, 0, moved)
+			assert.Equal(t, test.want, readTestTree(t, root))
+		})
+	}
+}
+
+func TestMigrateLayoutSamePath(t *testing.T) {
+	root := t.TempDir()
+	fs := NewFileSystemBase(root)
+	assert.NoError(t, fs.SetString("apple", "1"))
+	moved, err := fs.MigrateLayout(flatPath(root), flatPath(root))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, moved)
+	value, err := fs.GetString("apple")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", value)
+}
+
+func TestMigrateLayoutMissingDir(t *testing.T) {
+	root := t.TempDir()
+	fs := NewFileSystemBase(root)
+	_, err := fs.MigrateLayout(flatPath(filepath.Join(root, "missing")), shardedPath(root))
+	assert.True(t, os.IsNotExist(errors.Cause(err)))
+}
+
+func writeTestFile(t *testing.T, filePath string, value string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0700))
+	assert.NoError(t, os.WriteFile(filePath, []byte(value), 0600))
+}
+
+// Returns the contents of all files under root by their slash-separated relative paths.
+func readTestTree(t *testing.T, root string) map[string]string {
+	files := map[string]string{}
+	err := filepath.WalkDir(root, func(filePath string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filePath)
+		files[filepath.ToSlash(relPath)] = string(data)
+		return err
+	})
+	assert.NoError(t, err)
+	return files
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"time"
+)
+
+// Writes every file to both a primary and a secondary FileSystem, and reads from the primary.
+// With read fallback enabled, reads that fail on the primary for any reason other than the
+// file not existing are retried against the secondary.
+type MirrorFileSystem struct {
+	primary      FileSystem
+	secondary    FileSystem
+	readFallback bool
+}
+
+func NewMirrorFileSystem(primary FileSystem, secondary FileSystem, readFallback bool) *MirrorFileSystem {
+	return &MirrorFileSystem{primary: primary, secondary: secondary, readFallback: readFallback}
+}
+
+// Runs fn against the primary, falling back to the secondary if enabled.
+// Not found is a definitive answer and never falls back.
+func (m *MirrorFileSystem) read(name FSName, fn func(FileSystem) error) error {
+	err := fn(m.primary)
+	if err == nil || !m.readFallback || isNotFound(err) {
+		return err
+	}
+	log.Warn().Err(err).Str("name", string(name)).Msg("mirror read falling back to secondary")
+	return fn(m.secondary)
+}
+
+func (m *MirrorFileSystem) GetString(name FSName) (string, error) {
+	var value string
+	err := m.read(name, func(fs FileSystem) (err error) {
+		value, err = fs.GetString(name)
+		return err
+	})
+	return value, err
+}
+
+func (m *MirrorFileSystem) GetBytes(name FSName) ([]byte, error) {
+	var data []byte
+	err := m.read(name, func(fs FileSystem) (err error) {
+		data, err = fs.GetBytes(name)
+		return err
+	})
+	return data, err
+}
+
+func (m *MirrorFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	var file ReadonlyFile
+	err := m.read(name, func(fs FileSystem) (err error) {
+		file, err = fs.GetFile(name)
+		return err
+	})
+	return file, err
+}
+
+func (m *MirrorFileSystem) GetSeekableFile(name FSName) (io.ReadSeekCloser, int64, error) {
+	var file io.ReadSeekCloser
+	var size int64
+	err := m.read(name, func(fs FileSystem) (err error) {
+		file, size, err = fs.GetSeekableFile(name)
+		return err
+	})
+	return file, size, err
+}
+
+// Only falls back while opening, bytes already written to w cannot be taken back.
+func (m *MirrorFileSystem) WriteTo(name FSName, w io.Writer, offset int64, length int64) (int64, error) {
+	return writeFileTo(m, name, w, offset, length)
+}
+
+func (m *MirrorFileSystem) StreamArchive(prefix FSName, format ArchiveFormat, w io.Writer) error {
+	return streamArchive(m, prefix, format, w)
+}
+
+func (m *MirrorFileSystem) SetString(name FSName, value string) error {
+	if err := m.primary.SetString(name, value); err != nil {
+		return err
+	}
+	if err := m.secondary.SetString(name, value); err != nil {
+		return errors.WithMessage(err, "mirror to secondary")
+	}
+	return nil
+}
+
+func (m *MirrorFileSystem) SetFile(name FSName, value io.Reader) error {
+	if err := m.primary.SetFile(name, value); err != nil {
+		return err
+	}
+	// value is consumed, so copy back from the primary
+	if err := m.mirrorFile(name); err != nil {
+		return errors.WithMessage(err, "mirror to secondary")
+	}
+	return nil
+}
+
+func (m *MirrorFileSystem) mirrorFile(name FSName) error {
+	file, err := m.primary.GetFile(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return m.secondary.SetFile(name, file)
+}
+
+func (m *MirrorFileSystem) RemoveFile(name FSName) error {
+	if err := m.primary.RemoveFile(name); err != nil {
+		return err
+	}
+	if err := m.secondary.RemoveFileIfExists(name); err != nil {
+		return errors.WithMessage(err, "remove from secondary")
+	}
+	return nil
+}
+
+func (m *MirrorFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(m, name)
+}
+
+func (m *MirrorFileSystem) Stat(name FSName) (os.FileInfo, error) {
+	var stat os.FileInfo
+	err := m.read(name, func(fs FileSystem) (err error) {
+		stat, err = fs.Stat(name)
+		return err
+	})
+	return stat, err
+}
+
+func (m *MirrorFileSystem) MkDir(name FSName) error {
+	if err := m.primary.MkDir(name); err != nil {
+		return err
+	}
+	if err := m.secondary.MkDir(name); err != nil {
+		return errors.WithMessage(err, "mkdir on secondary")
+	}
+	return nil
+}
+
+func (m *MirrorFileSystem) ReadDir(name FSName) ([]os.DirEntry, error) {
+	var entries []os.DirEntry
+	err := m.read(name, func(fs FileSystem) (err error) {
+		entries, err = fs.ReadDir(name)
+		return err
+	})
+	return entries, err
+}
+
+func (m *MirrorFileSystem) ListFiles(prefix FSName) ([]FSName, error) {
+	var names []FSName
+	err := m.read(prefix, func(fs FileSystem) (err error) {
+		names, err = fs.ListFiles(prefix)
+		return err
+	})
+	return names, err
+}
+
+func (m *MirrorFileSystem) ListModifiedSince(prefix FSName, since time.Time) ([]FSName, error) {
+	var names []FSName
+	err := m.read(prefix, func(fs FileSystem) (err error) {
+		names, err = fs.ListModifiedSince(prefix, since)
+		return err
+	})
+	return names, err
+}
+
+func (m *MirrorFileSystem) TreeHash(prefix FSName) (string, error) {
+	var hash string
+	err := m.read(prefix, func(fs FileSystem) (err error) {
+		hash, err = fs.TreeHash(prefix)
+		return err
+	})
+	return hash, err
+}
+
+// Copies every file under prefix that is missing or different on the secondary from the
+// primary, e.g. to catch up after the secondary was unavailable. Files that fail to copy
+// do not stop the others and are returned together as a *BatchError. Returns the number
+// of copied files.
+func (m *MirrorFileSystem) Reconcile(prefix FSName) (int, error) {
+	names, err := m.primary.ListFiles(prefix)
+	if err != nil {
+		return 0, errors.WithMessage(err, "list primary")
+	}
+	batchErr := &BatchError{}
+	for _, name := range names {
+		inSync, err := m.inSync(name)
+		if err != nil {
+			batchErr.Failures = append(batchErr.Failures, &BatchFailure{Name: name, Err: err})
+			continue
+		}
+		if inSync {
+			continue
+		}
+		if err := m.mirrorFile(name); err != nil {
+			batchErr.Failures = append(batchErr.Failures, &BatchFailure{Name: name, Err: err})
+			continue
+		}
+		batchErr.Succeeded++
+	}
+	if len(batchErr.Failures) > 0 {
+		return batchErr.Succeeded, batchErr
+	}
+	return batchErr.Succeeded, nil
+}
+
+func (m *MirrorFileSystem) inSync(name FSName) (bool, error) {
+	secondaryHash, err := hashFromFileSystem(m.secondary, name, sha256.New())
+	if isNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.WithMessage(err, "hash secondary")
+	}
+	primaryHash, err := hashFromFileSystem(m.primary, name, sha256.New())
+	if err != nil {
+		return false, errors.WithMessage(err, "hash primary")
+	}
+	return primaryHash == secondaryHash, nil
+}
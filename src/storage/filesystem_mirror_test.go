@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+// Fails every read, like a backend that is down.
+type unavailableFileSystem struct {
+	FileSystem
+}
+
+func (u *unavailableFileSystem) GetString(FSName) (string, error) {
+	return "", errUnavailable
+}
+
+// Fails writes of one name.
+type failingWriteFileSystem struct {
+	FileSystem
+	name FSName
+}
+
+func (f *failingWriteFileSystem) SetFile(name FSName, value io.Reader) error {
+	if name == f.name {
+		return errUnavailable
+	}
+	return f.FileSystem.SetFile(name, value)
+}
+
+func TestMirrorWrites(t *testing.T) {
+	primary := NewFileSystemBase(t.TempDir())
+	secondary := NewFileSystemBase(t.TempDir())
+	m := NewMirrorFileSystem(primary, secondary, false)
+	assert.NoError(t, m.MkDir("dir"))
+	assert.NoError(t, m.SetString("dir/string", "value"))
+	assert.NoError(t, m.SetFile("dir/file", strings.NewReader("value")))
+	for _, fs := range []*FileSystemBase{primary, secondary} {
+		for _, name := range []FSName{"dir/string", "dir/file"} {
+			value, err := fs.GetString(name)
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+		}
+	}
+	assert.NoError(t, m.RemoveFile("dir/file"))
+	for _, fs := range []*FileSystemBase{primary, secondary} {
+		exists, err := fs.Exists("dir/file")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	}
+}
+
+func TestMirrorReadFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		unavailable  bool
+		readFallback bool
+		file         FSName
+		value        string
+		err          error
+	}{
+		{"primary", false, true, "file", "primary", nil},
+		{"no fallback", true, false, "file", "", errUnavailable},
+		{"fallback", true, true, "file", "secondary", nil},
+		{"not found does not fall back", false, true, "secondary only", "", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := NewFileSystemBase(t.TempDir())
+			secondary := NewFileSystemBase(t.TempDir())
+			assert.NoError(t, base.SetString("file", "primary"))
+			assert.NoError(t, secondary.SetString("file", "secondary"))
+			assert.NoError(t, secondary.SetString("secondary only", "secondary"))
+			var primary FileSystem = base
+			if test.unavailable {
+				primary = &unavailableFileSystem{base}
+			}
+			m := NewMirrorFileSystem(primary, secondary, test.readFallback)
+			value, err := m.GetString(test.file)
+			switch {
+			case test.err != nil:
+				assert.ErrorIs(t, err, test.err)
+			case test.value == "":
+				assert.True(t, isNotFound(err))
+			default:
+				assert.NoError(t, err)
+				assert.Equal(t, test.value, value)
+			}
+		})
+	}
+}
+
+func TestMirrorReconcile(t *testing.T) {
+	primary := NewFileSystemBase(t.TempDir())
+	secondary := NewFileSystemBase(t.TempDir())
+	for _, fs := range []*FileSystemBase{primary, secondary} {
+		assert.NoError(t, fs.MkDir("dir"))
+	}
+	assert.NoError(t, primary.SetString("dir/same", "value"))
+	assert.NoError(t, secondary.SetString("dir/same", "value"))
+	assert.NoError(t, primary.SetString("dir/missing", "value"))
+	assert.NoError(t, primary.SetString("dir/different", "new"))
+	assert.NoError(t, secondary.SetString("dir/different", "old"))
+	assert.NoError(t, primary.SetString("dir/failing", "value"))
+	assert.NoError(t, primary.SetString("outside", "value"))
+
+	m := NewMirrorFileSystem(primary, &failingWriteFileSystem{secondary, "dir/failing"}, false)
+	copied, err := m.Reconcile("dir")
+	assert.Equal(t, 2, copied)
+	var batchErr *BatchError
+	assert.ErrorAs(t, err, &batchErr)
+	assert.Len(t, batchErr.Failures, 1)
+	assert.Equal(t, FSName("dir/failing"), batchErr.Failures[0].Name)
+
+	for _, name := range []FSName{"dir/same", "dir/missing", "dir/different"} {
+		value, err := secondary.GetString(name)
+		assert.NoError(t, err)
+		primaryValue, err := primary.GetString(name)
+		assert.NoError(t, err)
+		assert.Equal(t, primaryValue, value)
+	}
+	exists, err := secondary.Exists("outside")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// nothing is left to copy once the secondary is back
+	m = NewMirrorFileSystem(primary, secondary, false)
+	copied, err = m.Reconcile("dir")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, copied)
+	copied, err = m.Reconcile("dir")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, copied)
+}
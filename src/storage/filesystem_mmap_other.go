@@ -0,0 +1,19 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package storage
+
+import (
+	"os"
+)
+
+// Memory mapping is not available on this platform, so the file is read into memory instead.
+// The slice is only valid until the returned close func is called.
+func (a *FileSystemBase) GetMappedFile(name FSName) ([]byte, func() error, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	data, err := os.ReadFile(a.resolvePath(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGetMappedFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []FileSystemOption
+		value string
+	}{
+		{"replace", nil, "value"},
+		{"empty", nil, ""},
+		{"direct", []FileSystemOption{WithWriteStrategy(WriteDirect, false)}, "value"},
+		{"fallback", []FileSystemOption{WithWriteStrategy(WriteReplace, true)}, "value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := NewFileSystemBase(t.TempDir(), test.opts...)
+			assert.NoError(t, fs.SetString("file", test.value))
+			data, closeFile, err := fs.GetMappedFile("file")
+			assert.NoError(t, err)
+			assert.Equal(t, test.value, string(data))
+
+			// rewrites, even shorter ones in place, do not affect the returned data
+			assert.NoError(t, fs.SetString("file", "x"))
+			assert.Equal(t, test.value, string(data))
+			assert.NoError(t, closeFile())
+		})
+	}
+}
+
+func TestGetMappedFileMissing(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	_, _, err := fs.GetMappedFile("missing")
+	assert.True(t, isNotFound(err))
+}
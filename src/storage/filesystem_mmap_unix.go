@@ -0,0 +1,47 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"syscall"
+)
+
+// Maps the file read-only into memory, e.g. for zip.NewReader(bytes.NewReader(b), int64(len(b))).
+// The slice is only valid until the returned close func is called. Writes normally replace
+// files, so the mapping keeps showing the contents at the time of the call. If writes may
+// copy into the file in place instead, see WriteDirect and WithTempDir, truncating it would
+// crash readers of the mapping, so the file is read into memory instead.
+func (a *FileSystemBase) GetMappedFile(name FSName) ([]byte, func() error, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.mayWriteInPlace() {
+		data, err := os.ReadFile(a.resolvePath(name))
+		if err != nil {
+			return nil, nil, err
+		}
+		return data, func() error { return nil }, nil
+	}
+	f, err := os.Open(a.resolvePath(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	// the mapping stays valid after the file is closed
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if stat.Size() == 0 {
+		return []byte{}, func() error { return nil }, nil
+	}
+	if int64(int(stat.Size())) != stat.Size() {
+		return nil, nil, errors.Errorf("file of %d bytes is too large to map", stat.Size())
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "mmap file")
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var ErrDestinationNotEmpty = errors.New("destination not empty")
+
+// Moves everything under src to dst by renaming the directory itself, which is atomic for
+// readers. Fails with ErrDestinationNotEmpty if dst already has files, see MergePrefix.
+func (a *FileSystemBase) MovePrefix(src FSName, dst FSName) error {
+	return a.movePrefix(src, dst, false)
+}
+
+// Like MovePrefix, but if dst already has files, moves the files one by one instead,
+// replacing those that exist in both. This is not atomic.
+func (a *FileSystemBase) MergePrefix(src FSName, dst FSName) error {
+	return a.movePrefix(src, dst, true)
+}
+
+func (a *FileSystemBase) movePrefix(src FSName, dst FSName, merge bool) (err error) {
+	defer a.trace("move prefix", src)(&err)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	srcPath := a.resolvePath(src)
+	dstPath := a.resolveWritePath(dst)
+	if _, err := os.Stat(srcPath); err != nil {
+		return err
+	}
+	empty, err := isEmptyDir(dstPath)
+	if err != nil {
+		return errors.WithMessage(err, "check destination")
+	}
+	if !empty && !merge {
+		return errors.WithMessagef(ErrDestinationNotEmpty, "%s", dst)
+	}
+	if empty {
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0700); err != nil {
+			return errors.WithMessage(err, "make destination parent")
+		}
+		// rename refuses to replace directories on some platforms, even empty ones
+		if err := os.Remove(dstPath); err != nil && !os.IsNotExist(err) {
+			return errors.WithMessage(err, "remove empty destination")
+		}
+		if err := os.Rename(srcPath, dstPath); err == nil {
+			return nil
+		} else if !isRenameUnsupported(err) {
+			return errors.WithMessage(err, "rename prefix")
+		}
+		a.warn(err, "falling back to moving files one by one")
+	}
+	return moveFiles(srcPath, dstPath, a.copyBufferSizeOrDefault())
+}
+
+// Returns true if the dir does not exist or has no entries.
+func isEmptyDir(dirPath string) (bool, error) {
+	f, err := os.Open(dirPath)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); errors.Is(err, io.EOF) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Moves each file under srcPath to the same relative path under dstPath, then removes
+// the emptied source dirs. Falls back to copying when renaming is not possible.
+func moveFiles(srcPath string, dstPath string, bufferSize int) error {
+	var dirs []string
+	err := filepath.WalkDir(srcPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcPath, filePath)
+		if err != nil {
+			return err
+		}
+		targetPath := filepath.Join(dstPath, relPath)
+		if d.IsDir() {
+			dirs = append(dirs, filePath)
+			return os.MkdirAll(targetPath, 0700)
+		}
+		if err := os.Rename(filePath, targetPath); err == nil {
+			return nil
+		} else if !isRenameUnsupported(err) {
+			return errors.WithMessagef(err, "move %s", relPath)
+		}
+		if err := copyFileInPlace(filePath, targetPath, bufferSize); err != nil {
+			return errors.WithMessagef(err, "copy %s", relPath)
+		}
+		return os.Remove(filePath)
+	})
+	if err != nil {
+		return err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if err := os.Remove(dir); err != nil {
+			return errors.WithMessage(err, "remove source dir")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMovePrefix(t *testing.T) {
+	tests := []struct {
+		name  string
+		dst   map[FSName]string
+		dstOK bool
+		merge bool
+		want  map[FSName]string
+		err   error
+	}{
+		{"missing destination", nil, false, false, map[FSName]string{"dst/a": "src", "dst/sub/b": "src"}, nil},
+		{"empty destination", nil, true, false, map[FSName]string{"dst/a": "src", "dst/sub/b": "src"}, nil},
+		{"non-empty destination", map[FSName]string{"dst/c": "dst"}, true, false, nil, ErrDestinationNotEmpty},
+		{"merge", map[FSName]string{"dst/a": "dst", "dst/c": "dst"}, true, true,
+			map[FSName]string{"dst/a": "src", "dst/c": "dst", "dst/sub/b": "src"}, nil},
+		{"merge into missing destination", nil, false, true, map[FSName]string{"dst/a": "src", "dst/sub/b": "src"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := NewFileSystemBase(t.TempDir())
+			assert.NoError(t, fs.MkDir("parent/src/sub"))
+			assert.NoError(t, fs.SetString("parent/src/a", "src"))
+			assert.NoError(t, fs.SetString("parent/src/sub/b", "src"))
+			if test.dstOK {
+				assert.NoError(t, fs.MkDir("parent/dst"))
+			}
+			for name, value := range test.dst {
+				assert.NoError(t, fs.SetString("parent/"+name, value))
+			}
+			var err error
+			if test.merge {
+				err = fs.MergePrefix("parent/src", "parent/dst")
+			} else {
+				err = fs.MovePrefix("parent/src", "parent/dst")
+			}
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				value, err := fs.GetString("parent/src/a")
+				assert.NoError(t, err)
+				assert.Equal(t, "src", value)
+				return
+			}
+			assert.NoError(t, err)
+			names, err := fs.ListFiles("parent")
+			assert.NoError(t, err)
+			var want []FSName
+			for name, value := range test.want {
+				stored, err := fs.GetString("parent/" + name)
+				assert.NoError(t, err)
+				assert.Equal(t, value, stored)
+				want = append(want, "parent/"+name)
+			}
+			assert.ElementsMatch(t, want, names)
+			exists, err := fs.Exists("parent/src")
+			assert.NoError(t, err)
+			assert.False(t, exists)
+		})
+	}
+}
+
+func TestMovePrefixMissingSource(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	assert.True(t, isNotFound(fs.MovePrefix("missing", "dst")))
+	assert.True(t, isNotFound(fs.MergePrefix("missing", "dst")))
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// The size of the parts SetFileMultipart uploads when no chunk size is given.
+const DefaultMultipartChunkSize = 8 * 1024 * 1024
+
+// A backend that accepts a file as separately uploaded parts, which are joined into the file
+// when the upload is completed, like S3 multipart uploads. Parts are numbered from 1.
+type MultipartUploader interface {
+	CreateMultipartUpload(ctx context.Context, name FSName) (string, error)
+	// Returns the ETag of the part, if the backend has one.
+	UploadPart(ctx context.Context, name FSName, uploadID string, partNumber int, part io.ReadSeeker) (string, error)
+	CompleteMultipartUpload(ctx context.Context, name FSName, uploadID string, parts []CompletedPart) error
+	// Removes the uploaded parts without creating the file.
+	AbortMultipartUpload(ctx context.Context, name FSName, uploadID string) error
+}
+
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// Uploads value in parts of chunkSize bytes, so only one part is held in memory at a time, no
+// matter how large value is or whether it can seek. A chunkSize of zero or less means
+// DefaultMultipartChunkSize. The upload is completed once value is exhausted, and aborted if
+// reading or uploading fails or ctx is done, so no orphaned parts are left behind.
+func SetFileMultipart(ctx context.Context, uploader MultipartUploader, name FSName, value io.Reader, chunkSize int64) (err error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultMultipartChunkSize
+	}
+	uploadID, err := uploader.CreateMultipartUpload(ctx, name)
+	if err != nil {
+		return errors.WithMessage(err, "create multipart upload")
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// ctx may be done already, but the parts have to be removed regardless
+		if abortErr := uploader.AbortMultipartUpload(context.Background(), name, uploadID); abortErr != nil {
+			err = errors.WithMessagef(err, "abort multipart upload: %v", abortErr)
+		}
+	}()
+	buffer := make([]byte, chunkSize)
+	var parts []CompletedPart
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, readErr := io.ReadFull(value, buffer)
+		// an empty file is uploaded as a single empty part
+		if readErr == io.EOF && len(parts) > 0 {
+			break
+		} else if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return errors.WithMessagef(readErr, "read part %d", len(parts)+1)
+		}
+		partNumber := len(parts) + 1
+		etag, err := uploader.UploadPart(ctx, name, uploadID, partNumber, bytes.NewReader(buffer[:n]))
+		if err != nil {
+			return errors.WithMessagef(err, "upload part %d", partNumber)
+		}
+		parts = append(parts, CompletedPart{PartNumber: partNumber, ETag: etag})
+		if readErr != nil {
+			break
+		}
+	}
+	if err := uploader.CompleteMultipartUpload(ctx, name, uploadID, parts); err != nil {
+		return errors.WithMessage(err, "complete multipart upload")
+	}
+	return nil
+}
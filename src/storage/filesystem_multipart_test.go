@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// Records the calls made by SetFileMultipart and keeps the parts in memory.
+type recordingUploader struct {
+	parts     [][]byte
+	failPart  int
+	completed bool
+	aborted   bool
+}
+
+func (r *recordingUploader) CreateMultipartUpload(context.Context, FSName) (string, error) {
+	return "upload", nil
+}
+
+func (r *recordingUploader) UploadPart(_ context.Context, _ FSName, _ string, partNumber int, part io.ReadSeeker) (string, error) {
+	if partNumber == r.failPart {
+		return "", errors.New("part failed")
+	}
+	data, err := io.ReadAll(part)
+	if err != nil {
+		return "", err
+	}
+	r.parts = append(r.parts, data)
+	return "", nil
+}
+
+func (r *recordingUploader) CompleteMultipartUpload(_ context.Context, _ FSName, _ string, parts []CompletedPart) error {
+	if len(parts) != len(r.parts) {
+		return errors.Errorf("completed %d of %d parts", len(parts), len(r.parts))
+	}
+	r.completed = true
+	return nil
+}
+
+func (r *recordingUploader) AbortMultipartUpload(context.Context, FSName, string) error {
+	r.aborted = true
+	return nil
+}
+
+// Cancels ctx once the first read is done.
+type cancelingReader struct {
+	io.Reader
+	cancel context.CancelFunc
+}
+
+func (c *cancelingReader) Read(p []byte) (int, error) {
+	defer c.cancel()
+	return c.Reader.Read(p)
+}
+
+func TestSetFileMultipartParts(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		chunkSize int64
+		parts     int
+	}{
+		{"empty", 0, 4, 1},
+		{"below chunk", 3, 4, 1},
+		{"one chunk", 4, 4, 1},
+		{"remainder", 10, 4, 3},
+		{"default chunk size", 10, 0, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value := bytes.Repeat([]byte("a"), test.size)
+			uploader := &recordingUploader{}
+			// reads one byte at a time, so parts cannot rely on a single read
+			err := SetFileMultipart(context.Background(), uploader, "file", iotest.OneByteReader(bytes.NewReader(value)), test.chunkSize)
+			assert.NoError(t, err)
+			assert.True(t, uploader.completed)
+			assert.False(t, uploader.aborted)
+			assert.Len(t, uploader.parts, test.parts)
+			assert.Equal(t, string(value), string(bytes.Join(uploader.parts, nil)))
+		})
+	}
+}
+
+func TestSetFileMultipartAborts(t *testing.T) {
+	tests := []struct {
+		name     string
+		reader   func(cancel context.CancelFunc) io.Reader
+		failPart int
+		err      error
+	}{
+		{"read error", func(context.CancelFunc) io.Reader {
+			return io.MultiReader(bytes.NewReader(make([]byte, 6)), iotest.ErrReader(io.ErrClosedPipe))
+		}, 0, io.ErrClosedPipe},
+		{"part error", func(context.CancelFunc) io.Reader {
+			return bytes.NewReader(make([]byte, 10))
+		}, 2, nil},
+		{"canceled", func(cancel context.CancelFunc) io.Reader {
+			return &cancelingReader{Reader: bytes.NewReader(make([]byte, 10)), cancel: cancel}
+		}, 0, context.Canceled},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			uploader := &recordingUploader{failPart: test.failPart}
+			err := SetFileMultipart(ctx, uploader, "file", test.reader(cancel), 4)
+			assert.Error(t, err)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+			}
+			assert.True(t, uploader.aborted)
+			assert.False(t, uploader.completed)
+		})
+	}
+}
+
+func TestWebDAVMultipartUpload(t *testing.T) {
+	tests := []struct {
+		name string
+		file FSName
+		size int
+	}{
+		{"empty", "file", 0},
+		{"single part", "file", 3},
+		{"many parts", "dir/sub/file", 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := newTestWebDAVFileSystem(t)
+			value := bytes.Repeat([]byte("abc"), test.size)
+			assert.NoError(t, SetFileMultipart(context.Background(), w, test.file, bytes.NewReader(value), 4))
+			data, err := w.GetBytes(test.file)
+			assert.NoError(t, err)
+			assert.Equal(t, string(value), string(data))
+			entries, err := w.ReadDir(webdavMultipartDir)
+			assert.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func TestWebDAVMultipartUploadLength(t *testing.T) {
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	var length int64
+	var transferEncoding []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodPut && request.URL.Path == "/dir/file" {
+			length, transferEncoding = request.ContentLength, request.TransferEncoding
+		}
+		handler.ServeHTTP(writer, request)
+	}))
+	t.Cleanup(server.Close)
+	w, err := NewWebDAVFileSystem(server.URL, "", "")
+	assert.NoError(t, err)
+	value := strings.Repeat("abc", 10)
+	assert.NoError(t, SetFileMultipart(context.Background(), w, "dir/file", strings.NewReader(value), 4))
+	assert.Equal(t, int64(len(value)), length)
+	assert.Empty(t, transferEncoding)
+
+	// parts that were never uploaded fail before anything is sent
+	uploadID, err := w.CreateMultipartUpload(context.Background(), "other")
+	assert.NoError(t, err)
+	err = w.CompleteMultipartUpload(context.Background(), "other", uploadID, []CompletedPart{{PartNumber: 1}})
+	assert.True(t, isNotFound(err))
+	_, err = w.Stat("other")
+	assert.True(t, isNotFound(err))
+}
+
+func TestWebDAVMultipartUploadAborted(t *testing.T) {
+	w := newTestWebDAVFileSystem(t)
+	value := io.MultiReader(bytes.NewReader(make([]byte, 10)), iotest.ErrReader(io.ErrClosedPipe))
+	assert.Error(t, SetFileMultipart(context.Background(), w, "file", value, 4))
+	_, err := w.Stat("file")
+	assert.True(t, isNotFound(err))
+	entries, err := w.ReadDir(webdavMultipartDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
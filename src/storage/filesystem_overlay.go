@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// Layers a writable FileSystem over a read-only one. Reads check the upper layer first,
+// writes always go to it, and removing a name that exists in the lower layer leaves a
+// hidden whiteout marker in the upper layer that hides it.
+type OverlayFileSystem struct {
+	lower FileSystem
+	upper FileSystem
+}
+
+func NewOverlayFileSystem(lower FileSystem, upper FileSystem) *OverlayFileSystem {
+	return &OverlayFileSystem{lower: lower, upper: upper}
+}
+
+func whiteoutName(name FSName) FSName {
+	return sidecarName(name, "wh")
+}
+
+func (o *OverlayFileSystem) isWhiteout(name FSName) (bool, error) {
+	if _, err := o.upper.Stat(whiteoutName(name)); isNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.WithMessage(err, "stat whiteout")
+	}
+	return true, nil
+}
+
+// Returns the layer that currently holds name.
+func (o *OverlayFileSystem) layer(op string, name FSName) (FileSystem, error) {
+	if _, err := o.upper.Stat(name); err == nil {
+		return o.upper, nil
+	} else if !isNotFound(err) {
+		return nil, err
+	}
+	whiteout, err := o.isWhiteout(name)
+	if err != nil {
+		return nil, err
+	}
+	if whiteout {
+		return nil, &os.PathError{Op: op, Path: string(name), Err: os.ErrNotExist}
+	}
+	return o.lower, nil
+}
+
+func (o *OverlayFileSystem) GetString(name FSName) (string, error) {
+	fs, err := o.layer("open", name)
+	if err != nil {
+		return "", err
+	}
+	return fs.GetString(name)
+}
+
+func (o *OverlayFileSystem) GetBytes(name FSName) ([]byte, error) {
+	fs, err := o.layer("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.GetBytes(name)
+}
+
+func (o *OverlayFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	fs, err := o.layer("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.GetFile(name)
+}
+
+func (o *OverlayFileSystem) GetSeekableFile(name FSName) (io.ReadSeekCloser, int64, error) {
+	fs, err := o.layer("open", name)
+	if err != nil {
+		return nil, 0, err
+	}
+	return fs.GetSeekableFile(name)
+}
+
+func (o *OverlayFileSystem) WriteTo(name FSName, w io.Writer, offset int64, length int64) (int64, error) {
+	fs, err := o.layer("open", name)
+	if err != nil {
+		return 0, err
+	}
+	return fs.WriteTo(name, w, offset, length)
+}
+
+func (o *OverlayFileSystem) StreamArchive(prefix FSName, format ArchiveFormat, w io.Writer) error {
+	return streamArchive(o, prefix, format, w)
+}
+
+func (o *OverlayFileSystem) SetString(name FSName, value string) error {
+	if err := o.upper.SetString(name, value); err != nil {
+		return err
+	}
+	return o.clearWhiteout(name)
+}
+
+func (o *OverlayFileSystem) SetFile(name FSName, value io.Reader) error {
+	if err := o.upper.SetFile(name, value); err != nil {
+		return err
+	}
+	return o.clearWhiteout(name)
+}
+
+func (o *OverlayFileSystem) clearWhiteout(name FSName) error {
+	if err := o.upper.RemoveFileIfExists(whiteoutName(name)); err != nil {
+		return errors.WithMessage(err, "remove whiteout")
+	}
+	return nil
+}
+
+func (o *OverlayFileSystem) RemoveFile(name FSName) error {
+	upperErr := o.upper.RemoveFile(name)
+	if upperErr != nil && !isNotFound(upperErr) {
+		return upperErr
+	}
+	if _, err := o.lower.Stat(name); isNotFound(err) {
+		return upperErr
+	} else if err != nil {
+		return err
+	}
+	whiteout, err := o.isWhiteout(name)
+	if err != nil {
+		return err
+	}
+	if whiteout && upperErr != nil {
+		return upperErr
+	}
+	return o.upper.SetString(whiteoutName(name), "")
+}
+
+func (o *OverlayFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(o, name)
+}
+
+func (o *OverlayFileSystem) Stat(name FSName) (os.FileInfo, error) {
+	fs, err := o.layer("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(name)
+}
+
+func (o *OverlayFileSystem) MkDir(name FSName) error {
+	return o.upper.MkDir(name)
+}
+
+func (o *OverlayFileSystem) ReadDir(name FSName) ([]os.DirEntry, error) {
+	upperEntries, upperErr := o.upper.ReadDir(name)
+	if upperErr != nil && !isNotFound(upperErr) {
+		return nil, upperErr
+	}
+	lowerEntries, lowerErr := o.lower.ReadDir(name)
+	if lowerErr != nil && !isNotFound(lowerErr) {
+		return nil, lowerErr
+	}
+	if upperErr != nil && lowerErr != nil {
+		return nil, upperErr
+	}
+	seen := map[string]bool{}
+	var entries []os.DirEntry
+	for _, entry := range upperEntries {
+		seen[entry.Name()] = true
+		entries = append(entries, entry)
+	}
+	for _, entry := range lowerEntries {
+		if seen[entry.Name()] {
+			continue
+		}
+		whiteout, err := o.isWhiteout(FSName(path.Join(string(name), entry.Name())))
+		if err != nil {
+			return nil, err
+		}
+		if !whiteout {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (o *OverlayFileSystem) ListFiles(prefix FSName) ([]FSName, error) {
+	return o.listFiles(func(fs FileSystem) ([]FSName, error) {
+		return fs.ListFiles(prefix)
+	})
+}
+
+func (o *OverlayFileSystem) ListModifiedSince(prefix FSName, since time.Time) ([]FSName, error) {
+	return o.listFiles(func(fs FileSystem) ([]FSName, error) {
+		return fs.ListModifiedSince(prefix, since)
+	})
+}
+
+func (o *OverlayFileSystem) listFiles(list func(FileSystem) ([]FSName, error)) ([]FSName, error) {
+	names, err := mergeFileLists(list, o.upper, o.lower)
+	if err != nil {
+		return nil, err
+	}
+	var visible []FSName
+	for _, name := range names {
+		whiteout, err := o.isWhiteout(name)
+		if err != nil {
+			return nil, err
+		}
+		if !whiteout {
+			visible = append(visible, name)
+		}
+	}
+	return visible, nil
+}
+
+func (o *OverlayFileSystem) TreeHash(prefix FSName) (string, error) {
+	return treeHash(o, prefix)
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newTestOverlayFileSystem(t *testing.T) (*OverlayFileSystem, *FileSystemBase, *FileSystemBase) {
+	lower := NewFileSystemBase(t.TempDir())
+	upper := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, lower.MkDir("dir"))
+	assert.NoError(t, lower.SetString("dir/lower", "lower"))
+	assert.NoError(t, lower.SetString("dir/both", "lower"))
+	assert.NoError(t, upper.MkDir("dir"))
+	assert.NoError(t, upper.SetString("dir/both", "upper"))
+	assert.NoError(t, upper.SetString("dir/upper", "upper"))
+	return NewOverlayFileSystem(lower, upper), lower, upper
+}
+
+func TestOverlayReads(t *testing.T) {
+	o, _, _ := newTestOverlayFileSystem(t)
+	tests := []struct {
+		name  string
+		file  FSName
+		value string
+	}{
+		{"lower only", "dir/lower", "lower"},
+		{"upper only", "dir/upper", "upper"},
+		{"upper wins", "dir/both", "upper"},
+		{"missing", "dir/missing", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := o.GetString(test.file)
+			if test.value == "" {
+				assert.True(t, isNotFound(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.value, value)
+		})
+	}
+}
+
+func TestOverlayWhiteouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     FSName
+		whiteout bool
+	}{
+		{"lower only", "dir/lower", true},
+		{"both layers", "dir/both", true},
+		{"upper only", "dir/upper", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o, lower, upper := newTestOverlayFileSystem(t)
+			lowerBefore, err := lower.ListFiles("")
+			assert.NoError(t, err)
+			assert.NoError(t, o.RemoveFile(test.file))
+
+			_, err = o.GetString(test.file)
+			assert.True(t, isNotFound(err))
+			_, err = o.Stat(test.file)
+			assert.True(t, isNotFound(err))
+			names, err := o.ListFiles("dir")
+			assert.NoError(t, err)
+			assert.NotContains(t, names, test.file)
+			entries, err := o.ReadDir("dir")
+			assert.NoError(t, err)
+			for _, entry := range entries {
+				assert.NotEqual(t, "dir/"+entry.Name(), string(test.file))
+				// whiteouts are hidden files
+				assert.NotEqual(t, string(whiteoutName(test.file)), "dir/"+entry.Name())
+			}
+			exists, err := upper.Exists(whiteoutName(test.file))
+			assert.NoError(t, err)
+			assert.Equal(t, test.whiteout, exists)
+
+			// the lower layer is never written
+			lowerAfter, err := lower.ListFiles("")
+			assert.NoError(t, err)
+			assert.Equal(t, lowerBefore, lowerAfter)
+
+			assert.True(t, isNotFound(o.RemoveFile(test.file)))
+			assert.NoError(t, o.RemoveFileIfExists(test.file))
+
+			// writing the name again clears its whiteout
+			assert.NoError(t, o.SetString(test.file, "new"))
+			value, err := o.GetString(test.file)
+			assert.NoError(t, err)
+			assert.Equal(t, "new", value)
+			exists, err = upper.Exists(whiteoutName(test.file))
+			assert.NoError(t, err)
+			assert.False(t, exists)
+		})
+	}
+}
+
+func TestOverlayListFiles(t *testing.T) {
+	o, _, _ := newTestOverlayFileSystem(t)
+	names, err := o.ListFiles("")
+	assert.NoError(t, err)
+	assert.Equal(t, []FSName{"dir/both", "dir/lower", "dir/upper"}, names)
+	entries, err := o.ReadDir("dir")
+	assert.NoError(t, err)
+	var entryNames []string
+	for _, entry := range entries {
+		entryNames = append(entryNames, entry.Name())
+	}
+	assert.Equal(t, []string{"both", "lower", "upper"}, entryNames)
+}
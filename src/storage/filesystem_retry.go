@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+const defaultIORetries = 3
+
+// Sets how many times an interrupted or otherwise transient read, write or open is retried
+// before failing. Zero disables retries. By default, operations are retried 3 times.
+func WithIORetries(retries int) FileSystemOption {
+	return func(a *FileSystemBase) {
+		if retries == 0 {
+			retries = -1
+		}
+		a.ioRetries = retries
+	}
+}
+
+func (a *FileSystemBase) maxIORetries() int {
+	if a.ioRetries == 0 {
+		return defaultIORetries
+	} else if a.ioRetries < 0 {
+		return 0
+	}
+	return a.ioRetries
+}
+
+// Retries on errors like EINTR that are expected to go away, but not on permanent ones like ENOSPC.
+func isTransientIOError(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
+
+func (a *FileSystemBase) retryTransient(fn func() error) error {
+	var err error
+	for i := 0; i <= a.maxIORetries(); i++ {
+		if err = fn(); err == nil || !isTransientIOError(err) {
+			return err
+		}
+		a.warn(err, "retrying transient error")
+	}
+	return errors.WithMessage(err, "retries exhausted")
+}
+
+// Reads the whole file, failing with ErrFileTooLarge if it is over the read limit.
+func (a *FileSystemBase) readFileLimited(filePath string) ([]byte, error) {
+	var f *os.File
+	err := a.retryTransient(func() error {
+		var err error
+		f, err = os.Open(filePath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	maxBytes := a.maxReadBytesOrDefault()
+	if stat, err := f.Stat(); err != nil {
+		return nil, err
+	} else if maxBytes > 0 && stat.Size() > maxBytes {
+		return nil, errors.WithMessagef(ErrFileTooLarge, "%d bytes", stat.Size())
+	}
+	reader := io.Reader(&retryReader{f, a})
+	if maxBytes > 0 {
+		// the file may have grown since the stat
+		reader = io.LimitReader(reader, maxBytes+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		return nil, errors.WithMessagef(ErrFileTooLarge, "over %d bytes", maxBytes)
+	}
+	return data, nil
+}
+
+type retryReader struct {
+	io.Reader
+	a *FileSystemBase
+}
+
+func (r *retryReader) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	for i := 0; i <= r.a.maxIORetries(); i++ {
+		n, err = r.Reader.Read(p)
+		// partial reads are returned as-is, the caller reads again anyway
+		if n > 0 || !isTransientIOError(err) {
+			return n, err
+		}
+		r.a.warn(err, "retrying transient read error")
+	}
+	return n, errors.WithMessage(err, "retries exhausted")
+}
+
+type retryWriter struct {
+	io.Writer
+	a *FileSystemBase
+}
+
+func (w *retryWriter) Write(p []byte) (int, error) {
+	written := 0
+	retries := 0
+	for {
+		n, err := w.Writer.Write(p[written:])
+		written += n
+		if err == nil || !isTransientIOError(err) {
+			return written, err
+		}
+		w.a.warn(err, "retrying transient write error")
+		// only count consecutive failures that made no progress
+		if n > 0 {
+			retries = 0
+		} else if retries++; retries > w.a.maxIORetries() {
+			return written, errors.WithMessage(err, "retries exhausted")
+		}
+	}
+}
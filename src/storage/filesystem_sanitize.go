@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Percent-encodes characters in each FSName component that are illegal on some filesystems,
+// such as colons or Windows reserved names like "CON". The encoding is deterministic and
+// reversible, so ListFiles returns the original FSNames.
+func WithNameSanitizer() FileSystemOption {
+	return func(a *FileSystemBase) {
+		resolvePath := a.resolvePath
+		a.resolvePath = func(name FSName) string {
+			return resolvePath(sanitizeName(name))
+		}
+		a.decodeName = unsanitizeComponent
+	}
+}
+
+func sanitizeName(name FSName) FSName {
+	components := strings.Split(string(name), "/")
+	for i, component := range components {
+		components[i] = sanitizeComponent(component)
+	}
+	return FSName(strings.Join(components, "/"))
+}
+
+func sanitizeComponent(component string) string {
+	// leave these as-is so path resolution can still clean them
+	if component == "" || component == "." || component == ".." {
+		return component
+	}
+	var builder strings.Builder
+	for i := 0; i < len(component); i++ {
+		c := component[i]
+		if isUnsafeNameChar(c) {
+			builder.WriteString(fmt.Sprintf("%%%02X", c))
+		} else {
+			builder.WriteByte(c)
+		}
+	}
+	result := builder.String()
+	// "CON" and "CON.txt" are both reserved
+	base := strings.ToUpper(strings.SplitN(result, ".", 2)[0])
+	if windowsReservedNames[base] {
+		result = fmt.Sprintf("%%%02X", result[0]) + result[1:]
+	}
+	// Windows silently strips trailing dots and spaces
+	if last := result[len(result)-1]; last == '.' || last == ' ' {
+		result = result[:len(result)-1] + fmt.Sprintf("%%%02X", last)
+	}
+	return result
+}
+
+func isUnsafeNameChar(c byte) bool {
+	if c < 0x20 || c == 0x7F {
+		return true
+	}
+	switch c {
+	case '%', '<', '>', ':', '"', '\\', '|', '?', '*':
+		return true
+	}
+	return false
+}
+
+func unsanitizeComponent(component string) (string, error) {
+	if !strings.Contains(component, "%") {
+		return component, nil
+	}
+	var builder strings.Builder
+	for i := 0; i < len(component); i++ {
+		if component[i] != '%' {
+			builder.WriteByte(component[i])
+			continue
+		}
+		if i+2 >= len(component) {
+			return "", errors.Errorf("truncated escape in %s", component)
+		}
+		c, err := strconv.ParseUint(component[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", errors.WithMessagef(err, "invalid escape in %s", component)
+		}
+		builder.WriteByte(byte(c))
+		i += 2
+	}
+	return builder.String(), nil
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/pkg/errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrSnapshotChanged = errors.New("file changed since snapshot")
+
+// Files up to this size are copied into the snapshot, larger ones are pinned by their digest.
+const snapshotCopyLimit = 1024 * 1024
+
+// Captures the files under prefix and returns a read-only fs.FS of them as of now, e.g. for
+// http.FS during a reproducible build. Paths are relative to prefix. Subsequent writes are
+// not visible: small files are copied into memory, large files are pinned by their SHA-256
+// digest and re-checked when opened, failing with ErrSnapshotChanged if they were replaced
+// since. Each file is captured consistently, but files written during the capture may be
+// included either before or after the write. The returned value also implements
+// fs.ReadDirFS and fs.StatFS.
+func SnapshotFS(fileSystem FileSystem, prefix FSName) (fs.FS, error) {
+	names, err := fileSystem.ListFiles(prefix)
+	if err != nil {
+		return nil, errors.WithMessage(err, "list files")
+	}
+	s := &snapshotFS{
+		fileSystem: fileSystem,
+		files:      map[string]*snapshotEntry{},
+		dirs:       map[string][]fs.DirEntry{".": nil},
+		created:    time.Now(),
+	}
+	for _, name := range names {
+		entry, err := captureSnapshotEntry(fileSystem, name)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "capture %s", name)
+		}
+		s.add(strings.TrimPrefix(strings.TrimPrefix(string(name), string(prefix)), "/"), entry)
+	}
+	for _, entries := range s.dirs {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+	}
+	return s, nil
+}
+
+type snapshotEntry struct {
+	name    FSName
+	size    int64
+	modTime time.Time
+	digest  [sha256.Size]byte
+	// nil for pinned files
+	data []byte
+}
+
+func captureSnapshotEntry(fileSystem FileSystem, name FSName) (*snapshotEntry, error) {
+	file, err := fileSystem.GetFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// stat the opened file, so the size matches what is read even if name is replaced meanwhile
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	entry := &snapshotEntry{name: name, size: stat.Size(), modTime: stat.ModTime()}
+	if stat.Size() <= snapshotCopyLimit {
+		if entry.data, err = io.ReadAll(file); err != nil {
+			return nil, err
+		}
+		entry.size = int64(len(entry.data))
+		entry.digest = sha256.Sum256(entry.data)
+		return entry, nil
+	}
+	hash := sha256.New()
+	if entry.size, err = io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	copy(entry.digest[:], hash.Sum(nil))
+	return entry, nil
+}
+
+type snapshotFS struct {
+	fileSystem FileSystem
+	files      map[string]*snapshotEntry
+	dirs       map[string][]fs.DirEntry
+	created    time.Time
+}
+
+func (s *snapshotFS) add(filePath string, entry *snapshotEntry) {
+	s.files[filePath] = entry
+	info := s.fileInfo(filePath, entry)
+	for {
+		dir := path.Dir(filePath)
+		_, known := s.dirs[dir]
+		s.dirs[dir] = append(s.dirs[dir], fs.FileInfoToDirEntry(info))
+		if known {
+			return
+		}
+		filePath = dir
+		info = s.dirInfo(dir)
+	}
+}
+
+func (s *snapshotFS) dirInfo(dir string) fs.FileInfo {
+	return &fileInfo{name: path.Base(dir), modTime: s.created, isDir: true}
+}
+
+func (s *snapshotFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if entries, ok := s.dirs[name]; ok {
+		return &fsAdapterDir{stat: s.dirInfo(name), entries: entries}, nil
+	}
+	entry, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.data != nil {
+		return newReaderFile(path.Base(name), bytes.NewReader(entry.data), entry.modTime), nil
+	}
+	file, err := s.openPinned(entry)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &snapshotFile{ReadonlyFile: file, info: s.fileInfo(name, entry)}, nil
+}
+
+// Reports the captured info, like files copied into the snapshot.
+type snapshotFile struct {
+	ReadonlyFile
+	info fs.FileInfo
+}
+
+func (f *snapshotFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Opens a pinned file and checks that it still has the captured content. The check reads
+// the opened file, so it stays valid even if name is replaced right after.
+func (s *snapshotFS) openPinned(entry *snapshotEntry) (ReadonlyFile, error) {
+	file, err := s.fileSystem.GetFile(entry.name)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if size != entry.size || !bytes.Equal(hash.Sum(nil), entry.digest[:]) {
+		file.Close()
+		return nil, errors.WithMessagef(ErrSnapshotChanged, "%s", entry.name)
+	}
+	return file, nil
+}
+
+func (s *snapshotFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, ok := s.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]fs.DirEntry{}, entries...), nil
+}
+
+func (s *snapshotFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := s.dirs[name]; ok {
+		return s.dirInfo(name), nil
+	}
+	entry, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return s.fileInfo(name, entry), nil
+}
+
+func (s *snapshotFS) fileInfo(filePath string, entry *snapshotEntry) fs.FileInfo {
+	return &fileInfo{name: path.Base(filePath), size: entry.size, modTime: entry.modTime}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var largeSnapshotValue = strings.Repeat("a", snapshotCopyLimit+1)
+
+func newTestSnapshot(t *testing.T, files map[FSName]string) (*FileSystemBase, fs.FS) {
+	base := NewFileSystemBase(t.TempDir())
+	for name, value := range files {
+		assert.NoError(t, base.MkDir(FSName(path.Dir(string(name)))))
+		assert.NoError(t, base.SetFile(name, strings.NewReader(value)))
+	}
+	snapshot, err := SnapshotFS(base, "app")
+	assert.NoError(t, err)
+	return base, snapshot
+}
+
+func TestSnapshotFS(t *testing.T) {
+	_, snapshot := newTestSnapshot(t, map[FSName]string{
+		"app/small":      "small",
+		"app/dir/nested": "nested",
+		"app/large":      largeSnapshotValue,
+		"other/file":     "other",
+	})
+	assert.NoError(t, fstest.TestFS(snapshot, "small", "dir/nested", "large"))
+	entries, err := fs.ReadDir(snapshot, ".")
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"dir", "large", "small"}, names)
+	_, err = snapshot.Open("../other/file")
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+	_, err = snapshot.Open("missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestSnapshotFSAfterWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    FSName
+		value   string
+		update  func(*FileSystemBase) error
+		wantErr error
+	}{
+		{"small rewritten", "app/small", "small", func(base *FileSystemBase) error {
+			return base.SetString("app/small", "rewritten")
+		}, nil},
+		{"small removed", "app/small", "small", func(base *FileSystemBase) error {
+			return base.RemoveFile("app/small")
+		}, nil},
+		{"large untouched", "app/large", largeSnapshotValue, func(base *FileSystemBase) error {
+			return base.SetString("app/small", "rewritten")
+		}, nil},
+		{"large rewritten with same content", "app/large", largeSnapshotValue, func(base *FileSystemBase) error {
+			return base.SetFile("app/large", strings.NewReader(largeSnapshotValue))
+		}, nil},
+		{"large rewritten", "app/large", largeSnapshotValue, func(base *FileSystemBase) error {
+			return base.SetFile("app/large", strings.NewReader(strings.Repeat("b", snapshotCopyLimit+1)))
+		}, ErrSnapshotChanged},
+		{"large truncated", "app/large", largeSnapshotValue, func(base *FileSystemBase) error {
+			return base.SetString("app/large", "short")
+		}, ErrSnapshotChanged},
+		{"large removed", "app/large", largeSnapshotValue, func(base *FileSystemBase) error {
+			return base.RemoveFile("app/large")
+		}, fs.ErrNotExist},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base, snapshot := newTestSnapshot(t, map[FSName]string{
+				"app/small": "small",
+				"app/large": largeSnapshotValue,
+			})
+			assert.NoError(t, test.update(base))
+			filePath := strings.TrimPrefix(string(test.file), "app/")
+			file, err := snapshot.Open(filePath)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			defer file.Close()
+			data, err := io.ReadAll(file)
+			assert.NoError(t, err)
+			assert.Equal(t, test.value, string(data))
+			stat, err := file.Stat()
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(test.value)), stat.Size())
+		})
+	}
+}
+
+func TestSnapshotFSStat(t *testing.T) {
+	base, snapshot := newTestSnapshot(t, map[FSName]string{"app/dir/file": "value"})
+	assert.NoError(t, base.SetString("app/dir/file", "rewritten"))
+	stat, err := fs.Stat(snapshot, "dir/file")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("value")), stat.Size())
+	stat, err = fs.Stat(snapshot, "dir")
+	assert.NoError(t, err)
+	assert.True(t, stat.IsDir())
+	_, err = fs.Stat(snapshot, "missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
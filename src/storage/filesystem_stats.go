@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A snapshot of storage-wide statistics, see Stats.
+type StorageStats struct {
+	// Files as returned by ListFiles, excluding hidden and temp files.
+	Files int
+	Bytes int64
+	// Mod time of the least recently written file, zero if there are no files.
+	Oldest time.Time
+	// Temp files of writes that are in progress or were interrupted, including those in the
+	// temp dir, see WithTempDir. A count that keeps growing indicates leaked temp files.
+	TempFiles int
+	TempBytes int64
+	// -1 if free space cannot be queried on this platform.
+	FreeBytes int64
+}
+
+// Computes the statistics of the whole store in a single walk under the read lock, followed by
+// one of the temp dir if it is set.
+func (a *FileSystemBase) Stats() (_ StorageStats, err error) {
+	defer a.trace("stats", "")(&err)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	root := a.resolvePath("")
+	stats := StorageStats{}
+	err = addStats(&stats, root, false)
+	if err == nil && a.tempDir != "" {
+		err = addStats(&stats, a.tempDir, true)
+	}
+	if err != nil {
+		return StorageStats{}, errors.WithMessage(err, "walk files")
+	}
+	stats.FreeBytes, err = a.freeBytesOrDefault()(root)
+	if errors.Is(err, errDiskSpaceUnsupported) {
+		stats.FreeBytes = -1
+	} else if err != nil {
+		return StorageStats{}, errors.WithMessage(err, "get free disk space")
+	}
+	return stats, nil
+}
+
+// Adds the files under root to stats, or only the temp files if tempOnly.
+func addStats(stats *StorageStats, root string, tempOnly bool) error {
+	return filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		hidden := filePath != root && strings.HasPrefix(d.Name(), ".")
+		if d.IsDir() {
+			if hidden {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		isTemp := isTempFileName(d.Name())
+		if !isTemp && (hidden || tempOnly) {
+			return nil
+		}
+		info, err := d.Info()
+		if isNotFound(err) {
+			// removed or renamed since listing the directory
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if isTemp {
+			stats.TempFiles++
+			stats.TempBytes += info.Size()
+			return nil
+		}
+		stats.Files++
+		stats.Bytes += info.Size()
+		if stats.Oldest.IsZero() || info.ModTime().Before(stats.Oldest) {
+			stats.Oldest = info.ModTime()
+		}
+		return nil
+	})
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	root := t.TempDir()
+	tempDir := t.TempDir()
+	fs := NewFileSystemBase(root, WithTempDir(tempDir, false))
+	createCompactTree(t, root, []string{
+		"a",
+		"dir/b",
+		"dir/.hidden",
+		".hidden/c",
+		"dir/.b" + tempFileInfix + "1",
+	})
+	createCompactTree(t, tempDir, []string{
+		".a" + tempFileInfix + "2",
+		// only temp files count in the temp dir
+		"other",
+	})
+	oldest := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(filepath.Join(root, "dir/b"), oldest, oldest))
+	fs.freeBytes = func(string) (int64, error) {
+		return 1000, nil
+	}
+
+	stats, err := fs.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Files)
+	assert.EqualValues(t, 2*len("value"), stats.Bytes)
+	assert.True(t, oldest.Equal(stats.Oldest), stats.Oldest)
+	assert.Equal(t, 2, stats.TempFiles)
+	assert.EqualValues(t, 2*len("value"), stats.TempBytes)
+	assert.EqualValues(t, 1000, stats.FreeBytes)
+
+	fs.freeBytes = func(string) (int64, error) {
+		return 0, errDiskSpaceUnsupported
+	}
+	stats, err = fs.Stats()
+	assert.NoError(t, err)
+	assert.EqualValues(t, -1, stats.FreeBytes)
+	errStatfs := errors.New("statfs failed")
+	fs.freeBytes = func(string) (int64, error) {
+		return 0, errStatfs
+	}
+	_, err = fs.Stats()
+	assert.ErrorIs(t, err, errStatfs)
+}
+
+func TestStatsEmpty(t *testing.T) {
+	stats, err := NewFileSystemBase(t.TempDir()).Stats()
+	assert.NoError(t, err)
+	assert.Zero(t, stats.Files)
+	assert.Zero(t, stats.Bytes)
+	assert.True(t, stats.Oldest.IsZero())
+	assert.Zero(t, stats.TempFiles)
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+)
+
+// Swaps the contents of two files with three renames under the write lock, e.g. to promote
+// a candidate config while keeping the active one as the new candidate. Readers never see
+// a mix of both, only one of the files may briefly be missing. If a rename fails, the
+// earlier ones are undone.
+func (a *FileSystemBase) SwapFiles(first FSName, second FSName) (err error) {
+	defer a.trace("swap files", first)(&err)
+	firstPath, secondPath := a.resolvePath(first), a.resolvePath(second)
+	dir, file := filepath.Split(firstPath)
+	if dir == "" {
+		dir = "."
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rename := a.renameOrDefault()
+	for _, filePath := range []string{firstPath, secondPath} {
+		if _, err := os.Stat(filePath); err != nil {
+			return err
+		}
+	}
+	// reserves a unique name, which the first rename then replaces
+	f, err := a.createTempFile(first, dir, file)
+	if err != nil {
+		return errors.WithMessage(err, "create temp file")
+	}
+	tempPath := f.Name()
+	f.Close()
+	if err := rename(firstPath, tempPath); err != nil {
+		os.Remove(tempPath)
+		return errors.WithMessagef(err, "move %s aside", first)
+	}
+	if err := rename(secondPath, firstPath); err != nil {
+		return a.undoSwap(errors.WithMessagef(err, "move %s to %s", second, first), [2]string{tempPath, firstPath})
+	}
+	if err := rename(tempPath, secondPath); err != nil {
+		return a.undoSwap(errors.WithMessagef(err, "move %s to %s", first, second), [2]string{firstPath, secondPath}, [2]string{tempPath, firstPath})
+	}
+	return nil
+}
+
+// Applies the given renames in order and returns err, annotated if restoring failed too.
+func (a *FileSystemBase) undoSwap(err error, renames ...[2]string) error {
+	rename := a.renameOrDefault()
+	for _, undo := range renames {
+		if undoErr := rename(undo[0], undo[1]); undoErr != nil {
+			a.warn(undoErr, "failed to restore swapped files")
+			return errors.WithMessagef(err, "restore failed: %v", undoErr)
+		}
+	}
+	return err
+}
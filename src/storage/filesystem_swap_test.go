@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestSwapFiles(t *testing.T) {
+	root := t.TempDir()
+	fs := NewFileSystemBase(root)
+	assert.NoError(t, fs.MkDir("dir"))
+	assert.NoError(t, fs.SetString("dir/first", "first"))
+	assert.NoError(t, fs.SetString("second", "second"))
+	assert.NoError(t, fs.SwapFiles("dir/first", "second"))
+	for name, want := range map[FSName]string{"dir/first": "second", "second": "first"} {
+		value, err := fs.GetString(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, value, name)
+	}
+	// no temp file is left behind
+	assert.Equal(t, []string{"dir/", "dir/first", "second"}, listCompactTree(t, root))
+
+	assert.True(t, isNotFound(fs.SwapFiles("dir/first", "missing")))
+	assert.True(t, isNotFound(fs.SwapFiles("missing", "second")))
+}
+
+func TestSwapFilesUndo(t *testing.T) {
+	errRename := errors.New("rename failed")
+	tests := []struct {
+		name string
+		// the renames that fail, counting from 1
+		failing       []int
+		restoreFailed bool
+	}{
+		{"first rename fails", []int{1}, false},
+		{"second rename fails", []int{2}, false},
+		{"third rename fails", []int{3}, false},
+		{"undo fails", []int{3, 4}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			fs := NewFileSystemBase(root)
+			assert.NoError(t, fs.SetString("first", "first"))
+			assert.NoError(t, fs.SetString("second", "second"))
+			renames := 0
+			fs.rename = func(source string, target string) error {
+				renames++
+				for _, failing := range test.failing {
+					if renames == failing {
+						return errRename
+					}
+				}
+				return os.Rename(source, target)
+			}
+			err := fs.SwapFiles("first", "second")
+			assert.ErrorIs(t, err, errRename)
+			if test.restoreFailed {
+				assert.Contains(t, err.Error(), "restore failed")
+				return
+			}
+			assert.NotContains(t, err.Error(), "restore failed")
+			for _, name := range []FSName{"first", "second"} {
+				value, err := fs.GetString(name)
+				assert.NoError(t, err)
+				assert.Equal(t, string(name), value)
+			}
+			assert.Equal(t, []string{"first", "second"}, listCompactTree(t, root))
+		})
+	}
+}
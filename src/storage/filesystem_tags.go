@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+)
+
+// Labels files with key-value tags, e.g. "env=prod" or "status=signed", that can be queried
+// with FindByTag. The tags of each file are stored in a hidden sidecar next to it, and the
+// reverse index is rebuilt from them on construction. Tags survive rewrites of the file and
+// are removed along with it.
+type TaggedFileSystem struct {
+	FileSystem
+	// held across sidecar writes, so the index always matches what is stored
+	mu sync.Mutex
+	// held by AddTags and RemoveFile, so tags are never saved for a file that was removed
+	// after checking that it exists
+	names nameLocks
+	tags  map[FSName]map[string]string
+	index map[string]map[string]map[FSName]struct{}
+}
+
+func NewTaggedFileSystem(fs FileSystem) (*TaggedFileSystem, error) {
+	t := &TaggedFileSystem{
+		FileSystem: fs,
+		tags:       map[FSName]map[string]string{},
+		index:      map[string]map[string]map[FSName]struct{}{},
+	}
+	names, err := fs.ListFiles("")
+	if err != nil {
+		return nil, errors.WithMessage(err, "list files")
+	}
+	for _, name := range names {
+		data, err := fs.GetBytes(tagsName(name))
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.WithMessagef(err, "get tags of %s", name)
+		}
+		tags := map[string]string{}
+		if err := json.Unmarshal(data, &tags); err != nil {
+			return nil, errors.WithMessagef(err, "unmarshal tags of %s", name)
+		}
+		t.setIndexed(name, tags)
+	}
+	return t, nil
+}
+
+func tagsName(name FSName) FSName {
+	return sidecarName(name, "tags")
+}
+
+// Replaces the indexed tags of name. Must be called with the lock held.
+func (t *TaggedFileSystem) setIndexed(name FSName, tags map[string]string) {
+	for key, value := range t.tags[name] {
+		delete(t.index[key][value], name)
+	}
+	if len(tags) == 0 {
+		delete(t.tags, name)
+		return
+	}
+	t.tags[name] = tags
+	for key, value := range tags {
+		if t.index[key] == nil {
+			t.index[key] = map[string]map[FSName]struct{}{}
+		}
+		if t.index[key][value] == nil {
+			t.index[key][value] = map[FSName]struct{}{}
+		}
+		t.index[key][value][name] = struct{}{}
+	}
+}
+
+// Persists and indexes the new tags of name. Must be called with the lock held.
+func (t *TaggedFileSystem) saveTags(name FSName, tags map[string]string) error {
+	if len(tags) == 0 {
+		if err := t.FileSystem.RemoveFileIfExists(tagsName(name)); err != nil {
+			return errors.WithMessage(err, "remove tags")
+		}
+	} else {
+		data, err := json.Marshal(tags)
+		if err != nil {
+			return errors.WithMessage(err, "marshal tags")
+		}
+		if err := t.FileSystem.SetFile(tagsName(name), bytes.NewReader(data)); err != nil {
+			return errors.WithMessage(err, "save tags")
+		}
+	}
+	t.setIndexed(name, tags)
+	return nil
+}
+
+// Returns a copy of the tags of name.
+func (t *TaggedFileSystem) GetTags(name FSName) map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return copyTags(t.tags[name])
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	copied := map[string]string{}
+	for key, value := range tags {
+		copied[key] = value
+	}
+	return copied
+}
+
+// Sets the given tags on name, overwriting the values of existing keys.
+func (t *TaggedFileSystem) AddTags(name FSName, tags map[string]string) error {
+	defer t.names.lock(string(name))()
+	if _, err := t.FileSystem.Stat(name); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	updated := copyTags(t.tags[name])
+	for key, value := range tags {
+		updated[key] = value
+	}
+	return t.saveTags(name, updated)
+}
+
+// Removes the tags with the given keys from name. Missing keys are ignored.
+func (t *TaggedFileSystem) RemoveTags(name FSName, keys ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	updated := copyTags(t.tags[name])
+	for _, key := range keys {
+		delete(updated, key)
+	}
+	return t.saveTags(name, updated)
+}
+
+// Returns the names tagged with key=value, sorted.
+func (t *TaggedFileSystem) FindByTag(key string, value string) ([]FSName, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var names []FSName
+	for name := range t.index[key][value] {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+	return names, nil
+}
+
+func (t *TaggedFileSystem) RemoveFile(name FSName) error {
+	defer t.names.lock(string(name))()
+	if err := t.FileSystem.RemoveFile(name); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.saveTags(name, nil)
+}
+
+func (t *TaggedFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(t, name)
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestTaggedFileSystem(t *testing.T, fs FileSystem, files ...FSName) *TaggedFileSystem {
+	for _, name := range files {
+		assert.NoError(t, fs.SetString(name, "value"))
+	}
+	tagged, err := NewTaggedFileSystem(fs)
+	assert.NoError(t, err)
+	return tagged
+}
+
+func TestTaggedFileSystem(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(*TaggedFileSystem) error
+		want   map[FSName]map[string]string
+	}{
+		{"add", func(fs *TaggedFileSystem) error {
+			return fs.AddTags("a", map[string]string{"env": "prod", "status": "signed"})
+		}, map[FSName]map[string]string{"a": {"env": "prod", "status": "signed"}, "b": {}}},
+		{"overwrite", func(fs *TaggedFileSystem) error {
+			if err := fs.AddTags("a", map[string]string{"env": "prod"}); err != nil {
+				return err
+			}
+			return fs.AddTags("a", map[string]string{"env": "dev"})
+		}, map[FSName]map[string]string{"a": {"env": "dev"}, "b": {}}},
+		{"remove tag", func(fs *TaggedFileSystem) error {
+			if err := fs.AddTags("a", map[string]string{"env": "prod", "status": "signed"}); err != nil {
+				return err
+			}
+			return fs.RemoveTags("a", "status", "missing")
+		}, map[FSName]map[string]string{"a": {"env": "prod"}, "b": {}}},
+		{"remove all tags", func(fs *TaggedFileSystem) error {
+			if err := fs.AddTags("a", map[string]string{"env": "prod"}); err != nil {
+				return err
+			}
+			return fs.RemoveTags("a", "env")
+		}, map[FSName]map[string]string{"a": {}, "b": {}}},
+		{"rewrite keeps tags", func(fs *TaggedFileSystem) error {
+			if err := fs.AddTags("a", map[string]string{"env": "prod"}); err != nil {
+				return err
+			}
+			return fs.SetString("a", "rewritten")
+		}, map[FSName]map[string]string{"a": {"env": "prod"}, "b": {}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := NewFileSystemBase(t.TempDir())
+			fs := newTestTaggedFileSystem(t, base, "a", "b")
+			assert.NoError(t, test.update(fs))
+			for name, want := range test.want {
+				assert.Equal(t, want, fs.GetTags(name), name)
+			}
+			assertTagIndex(t, fs, test.want)
+
+			// the index is rebuilt from the sidecars
+			rebuilt, err := NewTaggedFileSystem(base)
+			assert.NoError(t, err)
+			for name, want := range test.want {
+				assert.Equal(t, want, rebuilt.GetTags(name), name)
+			}
+			assertTagIndex(t, rebuilt, test.want)
+		})
+	}
+}
+
+// Checks that FindByTag returns exactly the names tagged in want.
+func assertTagIndex(t *testing.T, fs *TaggedFileSystem, want map[FSName]map[string]string) {
+	for _, key := range []string{"env", "status"} {
+		for _, value := range []string{"prod", "dev", "signed"} {
+			var expected []FSName
+			for _, name := range []FSName{"a", "b"} {
+				if tagValue, ok := want[name][key]; ok && tagValue == value {
+					expected = append(expected, name)
+				}
+			}
+			found, err := fs.FindByTag(key, value)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, found, "%s=%s", key, value)
+		}
+	}
+}
+
+func TestTaggedFileSystemFindByTag(t *testing.T) {
+	fs := newTestTaggedFileSystem(t, NewFileSystemBase(t.TempDir()), "c", "a", "b")
+	for _, name := range []FSName{"c", "a", "b"} {
+		assert.NoError(t, fs.AddTags(name, map[string]string{"env": "prod"}))
+	}
+	found, err := fs.FindByTag("env", "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, []FSName{"a", "b", "c"}, found)
+	found, err = fs.FindByTag("env", "missing")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+func TestTaggedFileSystemRemoveFile(t *testing.T) {
+	base := NewFileSystemBase(t.TempDir())
+	fs := newTestTaggedFileSystem(t, base, "a", "b")
+	assert.NoError(t, fs.AddTags("a", map[string]string{"env": "prod"}))
+	assert.NoError(t, fs.AddTags("b", map[string]string{"env": "prod"}))
+	assert.NoError(t, fs.RemoveFile("a"))
+	found, err := fs.FindByTag("env", "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, []FSName{"b"}, found)
+	_, err = base.Stat(tagsName("a"))
+	assert.True(t, isNotFound(err))
+
+	// a recreated file starts untagged, also after a rebuild
+	assert.NoError(t, fs.SetString("a", "value"))
+	assert.Empty(t, fs.GetTags("a"))
+	rebuilt, err := NewTaggedFileSystem(base)
+	assert.NoError(t, err)
+	assert.Empty(t, rebuilt.GetTags("a"))
+	found, err = rebuilt.FindByTag("env", "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, []FSName{"b"}, found)
+
+	assert.NoError(t, fs.RemoveFileIfExists("missing"))
+	assert.True(t, isNotFound(fs.RemoveFile("missing")))
+}
+
+func TestTaggedFileSystemAddTagsMissingFile(t *testing.T) {
+	fs := newTestTaggedFileSystem(t, NewFileSystemBase(t.TempDir()))
+	assert.True(t, isNotFound(fs.AddTags("missing", map[string]string{"env": "prod"})))
+	found, err := fs.FindByTag("env", "prod")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+// Calls afterStat once a file has been found, before Stat returns.
+type statHookFileSystem struct {
+	FileSystem
+	afterStat func()
+}
+
+func (h *statHookFileSystem) Stat(name FSName) (os.FileInfo, error) {
+	info, err := h.FileSystem.Stat(name)
+	if err == nil && h.afterStat != nil {
+		h.afterStat()
+	}
+	return info, err
+}
+
+func TestTaggedFileSystemAddTagsDuringRemove(t *testing.T) {
+	base := NewFileSystemBase(t.TempDir())
+	hook := &statHookFileSystem{FileSystem: base}
+	fs := newTestTaggedFileSystem(t, hook, "a")
+	removed := make(chan error, 1)
+	hook.afterStat = func() {
+		hook.afterStat = nil
+		// a remove between checking that the file exists and saving its tags, unless it
+		// waits for the tags to be saved
+		go func() {
+			removed <- fs.RemoveFile("a")
+		}()
+		select {
+		case err := <-removed:
+			removed <- err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	assert.NoError(t, fs.AddTags("a", map[string]string{"env": "prod"}))
+	assert.NoError(t, <-removed)
+	// no orphaned sidecar or index entry is left
+	assert.Empty(t, fs.GetTags("a"))
+	found, err := fs.FindByTag("env", "prod")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+	_, err = base.Stat(tagsName("a"))
+	assert.True(t, isNotFound(err))
+}
+
+func TestTaggedFileSystemCorruptSidecar(t *testing.T) {
+	base := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, base.SetString("a", "value"))
+	assert.NoError(t, base.SetString(tagsName("a"), "not json"))
+	_, err := NewTaggedFileSystem(base)
+	assert.Error(t, err)
+}
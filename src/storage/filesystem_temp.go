@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"fmt"
+	"go.uber.org/atomic"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Temp files are hidden, so they are skipped by ListFiles while a write is in progress.
+const tempFileInfix = ".tmp-"
+
+func tempFilePattern(file string) string {
+	return "." + file + tempFileInfix + "*"
+}
+
+func isTempFileName(file string) bool {
+	return strings.HasPrefix(file, ".") && strings.Contains(file, tempFileInfix)
+}
+
+// Sets the func that picks the temp file name used while writing name. The returned name
+// is created next to the target. If it already exists, e.g. left over by a crashed process,
+// the func is called again, and the write fails after maxTempNameAttempts names that all
+// existed. By default, a random name is used.
+func WithTempNamer(namer func(name FSName) string) FileSystemOption {
+	return func(a *FileSystemBase) {
+		a.tempNamer = namer
+	}
+}
+
+// Returns a deterministic temp namer that appends an increasing counter, so concurrent
+// writes to the same name do not collide. The counter starts over in every process, so the
+// names of temp files left over by an earlier one are skipped, see WithTempNamer.
+func NewSequentialTempNamer() func(name FSName) string {
+	counter := atomic.NewUint64(0)
+	return func(name FSName) string {
+		return fmt.Sprintf(".%s%s%d", path.Base(string(name)), tempFileInfix, counter.Inc())
+	}
+}
+
+const maxTempNameAttempts = 100
+
+func (a *FileSystemBase) createTempFile(name FSName, dir string, file string) (*os.File, error) {
+	if a.tempNamer == nil {
+		return ioutil.TempFile(dir, tempFilePattern(file))
+	}
+	var err error
+	for i := 0; i < maxTempNameAttempts; i++ {
+		var f *os.File
+		f, err = os.OpenFile(filepath.Join(dir, a.tempNamer(name)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrTempDirNotAtomic = errors.New("temp dir is on a different filesystem than the store")
+
+// Writes temp files into dir instead of next to their target, e.g. to keep scratch space for
+// large uploads out of the store. If dir is on the same filesystem as the store, the final
+// rename stays atomic. Otherwise, files are copied into place, so readers may briefly see
+// partial content. With requireAtomic, a dir on a different filesystem is rejected, and all
+// writes fail with ErrTempDirNotAtomic. The dir is checked once all options are applied, so
+// a warning about it reaches the logger regardless of the order of the options.
+func WithTempDir(dir string, requireAtomic bool) FileSystemOption {
+	return func(a *FileSystemBase) {
+		a.tempDir = filepath.Clean(dir)
+		a.tempDirAtomic = requireAtomic
+	}
+}
+
+// Creates the temp dir and checks whether temp files can be renamed from it into the store.
+func (a *FileSystemBase) probeTempDir() {
+	a.tempDirCopy = false
+	a.tempDirErr = nil
+	if err := os.MkdirAll(a.tempDir, 0700); err != nil {
+		a.tempDirErr = errors.WithMessage(err, "make temp dir")
+		return
+	}
+	// the store may be new, and its root is needed for the probe
+	root := a.resolvePath("")
+	if err := os.MkdirAll(root, 0700); err != nil {
+		a.tempDirErr = errors.WithMessage(err, "make root dir")
+		return
+	}
+	err := a.probeRename(a.tempDir, root)
+	if err == nil {
+		return
+	} else if !isRenameUnsupported(err) {
+		a.tempDirErr = errors.WithMessage(err, "probe temp dir")
+		return
+	}
+	if a.tempDirAtomic {
+		a.tempDirErr = errors.WithMessage(ErrTempDirNotAtomic, a.tempDir)
+		return
+	}
+	a.tempDirCopy = true
+	a.warn(err, "temp dir is on a different filesystem, falling back to copy")
+}
+
+// Checks that a file can be renamed from sourceDir into targetDir.
+func (a *FileSystemBase) probeRename(sourceDir string, targetDir string) error {
+	f, err := os.CreateTemp(sourceDir, tempFilePattern("probe"))
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	target := filepath.Join(targetDir, filepath.Base(f.Name()))
+	if err := a.renameOrDefault()(f.Name(), target); err != nil {
+		return err
+	}
+	return os.Remove(target)
+}
+
+// Returns the dir that the temp file for targetPath is written to.
+func (a *FileSystemBase) tempFileDir(targetPath string) string {
+	if a.tempDir != "" {
+		return a.tempDir
+	}
+	dir := filepath.Dir(targetPath)
+	if dir == "" {
+		dir = "."
+	}
+	return dir
+}
+
+// Whether tempPath must be copied into place, since it cannot be renamed across filesystems.
+func (a *FileSystemBase) isCopiedTempFile(tempPath string) bool {
+	return a.tempDirCopy && strings.HasPrefix(tempPath, a.tempDir+string(filepath.Separator))
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrInvalidTenant = errors.New("invalid tenant")
+
+// Resolves the tenant of a request, e.g. from a value stored in its context.
+type TenantResolver func(context.Context) string
+
+// Confines all names to a per-tenant namespace of the underlying FileSystem.
+// Names are cleaned before prefixing, so "../" cannot escape into another tenant.
+type TenantFileSystem struct {
+	fs     FileSystem
+	prefix string
+}
+
+func NewTenantFileSystem(fs FileSystem, tenant string) (*TenantFileSystem, error) {
+	if tenant == "" || tenant == "." || tenant == ".." || strings.ContainsAny(tenant, `/\`) {
+		return nil, errors.WithMessagef(ErrInvalidTenant, "%q", tenant)
+	}
+	return &TenantFileSystem{fs: fs, prefix: tenant}, nil
+}
+
+// Scopes fs to the tenant that resolve returns for ctx.
+func NewTenantFileSystemFromContext(ctx context.Context, fs FileSystem, resolve TenantResolver) (*TenantFileSystem, error) {
+	return NewTenantFileSystem(fs, resolve(ctx))
+}
+
+func (t *TenantFileSystem) scope(name FSName) FSName {
+	return FSName(path.Join(t.prefix, path.Clean("/"+string(name))))
+}
+
+func (t *TenantFileSystem) unscope(name FSName) FSName {
+	return FSName(strings.TrimPrefix(strings.TrimPrefix(string(name), t.prefix), "/"))
+}
+
+func (t *TenantFileSystem) unscopeAll(names []FSName, err error) ([]FSName, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range names {
+		names[i] = t.unscope(name)
+	}
+	return names, nil
+}
+
+func (t *TenantFileSystem) GetString(name FSName) (string, error) {
+	return t.fs.GetString(t.scope(name))
+}
+
+func (t *TenantFileSystem) GetBytes(name FSName) ([]byte, error) {
+	return t.fs.GetBytes(t.scope(name))
+}
+
+func (t *TenantFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	return t.fs.GetFile(t.scope(name))
+}
+
+func (t *TenantFileSystem) GetSeekableFile(name FSName) (io.ReadSeekCloser, int64, error) {
+	return t.fs.GetSeekableFile(t.scope(name))
+}
+
+func (t *TenantFileSystem) WriteTo(name FSName, w io.Writer, offset int64, length int64) (int64, error) {
+	return t.fs.WriteTo(t.scope(name), w, offset, length)
+}
+
+func (t *TenantFileSystem) StreamArchive(prefix FSName, format ArchiveFormat, w io.Writer) error {
+	return streamArchive(t, prefix, format, w)
+}
+
+func (t *TenantFileSystem) SetString(name FSName, value string) error {
+	return t.fs.SetString(t.scope(name), value)
+}
+
+func (t *TenantFileSystem) SetFile(name FSName, value io.Reader) error {
+	return t.fs.SetFile(t.scope(name), value)
+}
+
+func (t *TenantFileSystem) RemoveFile(name FSName) error {
+	return t.fs.RemoveFile(t.scope(name))
+}
+
+func (t *TenantFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(t, name)
+}
+
+func (t *TenantFileSystem) Stat(name FSName) (os.FileInfo, error) {
+	return t.fs.Stat(t.scope(name))
+}
+
+func (t *TenantFileSystem) MkDir(name FSName) error {
+	return t.fs.MkDir(t.scope(name))
+}
+
+func (t *TenantFileSystem) ReadDir(name FSName) ([]os.DirEntry, error) {
+	return t.fs.ReadDir(t.scope(name))
+}
+
+func (t *TenantFileSystem) ListFiles(prefix FSName) ([]FSName, error) {
+	return t.unscopeAll(t.fs.ListFiles(t.scope(prefix)))
+}
+
+func (t *TenantFileSystem) ListModifiedSince(prefix FSName, since time.Time) ([]FSName, error) {
+	return t.unscopeAll(t.fs.ListModifiedSince(t.scope(prefix), since))
+}
+
+func (t *TenantFileSystem) TreeHash(prefix FSName) (string, error) {
+	return t.fs.TreeHash(t.scope(prefix))
+}
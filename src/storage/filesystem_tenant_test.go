@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTenantInvalid(t *testing.T) {
+	base := NewFileSystemBase(t.TempDir())
+	for _, tenant := range []string{"", ".", "..", "a/b", `a\b`} {
+		t.Run(tenant, func(t *testing.T) {
+			_, err := NewTenantFileSystem(base, tenant)
+			assert.ErrorIs(t, err, ErrInvalidTenant)
+		})
+	}
+}
+
+func TestTenantScope(t *testing.T) {
+	base := NewFileSystemBase(t.TempDir())
+	tenant, err := NewTenantFileSystem(base, "a")
+	assert.NoError(t, err)
+	tests := []struct {
+		name   string
+		file   FSName
+		scoped FSName
+	}{
+		{"plain", "file", "a/file"},
+		{"nested", "dir/file", "a/dir/file"},
+		{"absolute", "/file", "a/file"},
+		{"parent", "../b/file", "a/b/file"},
+		{"nested parent", "dir/../../../b/file", "a/b/file"},
+		{"root", "", "a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.scoped, tenant.scope(test.file))
+		})
+	}
+}
+
+func TestTenantEscape(t *testing.T) {
+	base := NewFileSystemBase(t.TempDir())
+	a, err := NewTenantFileSystem(base, "a")
+	assert.NoError(t, err)
+	b, err := NewTenantFileSystem(base, "b")
+	assert.NoError(t, err)
+	assert.NoError(t, b.MkDir(""))
+	assert.NoError(t, b.SetString("secret", "b's secret"))
+
+	for _, name := range []FSName{"../b/secret", "/../b/secret", "x/../../b/secret"} {
+		t.Run(string(name), func(t *testing.T) {
+			_, err := a.GetString(name)
+			assert.True(t, isNotFound(err))
+			assert.True(t, isNotFound(a.RemoveFile(name)))
+		})
+	}
+	// writes land in a's namespace instead of overwriting b's files
+	assert.NoError(t, a.MkDir("b"))
+	assert.NoError(t, a.SetString("../b/secret", "overwritten"))
+	value, err := b.GetString("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "b's secret", value)
+	value, err = base.GetString("a/b/secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "overwritten", value)
+}
+
+func TestTenantListFiles(t *testing.T) {
+	base := NewFileSystemBase(t.TempDir())
+	tenant, err := NewTenantFileSystemFromContext(context.Background(), base, func(context.Context) string {
+		return "a"
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, base.MkDir("a/dir"))
+	assert.NoError(t, base.MkDir("ab"))
+	assert.NoError(t, base.SetString("a/dir/file", "value"))
+	assert.NoError(t, base.SetString("a/file", "value"))
+	assert.NoError(t, base.SetString("ab/file", "other tenant"))
+	names, err := tenant.ListFiles("")
+	assert.NoError(t, err)
+	assert.Equal(t, []FSName{"dir/file", "file"}, names)
+	names, err = tenant.ListFiles("dir")
+	assert.NoError(t, err)
+	assert.Equal(t, []FSName{"dir/file"}, names)
+}
@@ -0,0 +1,591 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestNameSanitizer(t *testing.T) {
+	root := t.TempDir()
+	fs := NewFileSystemBase(root, WithNameSanitizer())
+	names := []FSName{
+		"CON",
+		"nul.txt",
+		"com.example:app",
+		"dir/a<b>|c?*",
+		"100%",
+		"trailing.",
+		"アプリ/名前",
+	}
+	assert.NoError(t, fs.MkDir("dir"))
+	assert.NoError(t, fs.MkDir("アプリ"))
+	for _, name := range names {
+		assert.NoError(t, fs.SetString(name, string(name)))
+	}
+	for _, name := range names {
+		value, err := fs.GetString(name)
+		assert.NoError(t, err)
+		assert.Equal(t, string(name), value)
+	}
+	_, err := os.Stat(filepath.Join(root, "%43ON"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(root, "com.example%3Aapp"))
+	assert.NoError(t, err)
+	listed, err := fs.ListFiles("")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, names, listed)
+	assertReadDirRoundTrip(t, fs, "", names)
+}
+
+// Checks that the entries of ReadDir can be read back by name, like job.go does.
+func assertReadDirRoundTrip(t *testing.T, fs *FileSystemBase, dir FSName, names []FSName) {
+	entries, err := fs.ReadDir(dir)
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		name := FSName(path.Join(string(dir), entry.Name()))
+		if entry.IsDir() {
+			assertReadDirRoundTrip(t, fs, name, names)
+			continue
+		}
+		assert.Contains(t, names, name)
+		info, err := entry.Info()
+		assert.NoError(t, err)
+		assert.Equal(t, entry.Name(), info.Name())
+		file, err := fs.GetFile(name)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(file)
+		assert.NoError(t, err)
+		assert.NoError(t, file.Close())
+		assert.Equal(t, string(name), string(data))
+	}
+}
+
+func TestLongNameHashing(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir(), WithNameSanitizer(), WithLongNameHashing())
+	long := strings.Repeat("com.example:", 30)
+	names := []FSName{
+		FSName(long),
+		FSName("dir/" + long),
+		"short",
+	}
+	assert.NoError(t, fs.MkDir("dir"))
+	for _, name := range names {
+		assert.NoError(t, fs.SetString(name, string(name)))
+	}
+	for _, name := range names {
+		value, err := fs.GetString(name)
+		assert.NoError(t, err)
+		assert.Equal(t, string(name), value)
+	}
+	listed, err := fs.ListFiles("")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, names, listed)
+	assertReadDirRoundTrip(t, fs, "", names)
+}
+
+func TestLongNameHashingNearLimit(t *testing.T) {
+	for _, length := range []int{200, 223, 224, 240, 250, 255, 256, 360} {
+		t.Run(fmt.Sprint(length), func(t *testing.T) {
+			root := t.TempDir()
+			fs := NewFileSystemBase(root, WithLongNameHashing())
+			name := FSName(strings.Repeat("a", length))
+			assert.NoError(t, fs.SetString(name, "value"))
+			value, err := fs.GetString(name)
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+			listed, err := fs.ListFiles("")
+			assert.NoError(t, err)
+			assert.Equal(t, []FSName{name}, listed)
+			assert.NoError(t, fs.RemoveFile(name))
+			records, err := os.ReadDir(filepath.Join(root, longNamesDir))
+			if !os.IsNotExist(err) {
+				assert.NoError(t, err)
+			}
+			assert.Empty(t, records)
+		})
+	}
+}
+
+func TestLongNameHashingReadsDoNotRecord(t *testing.T) {
+	root := t.TempDir()
+	fs := NewFileSystemBase(root, WithLongNameHashing())
+	name := FSName(strings.Repeat("a", 300))
+	_, err := fs.GetString(name)
+	assert.True(t, os.IsNotExist(err))
+	exists, err := fs.Exists(name)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	_, err = os.Stat(filepath.Join(root, longNamesDir))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSetFileZeroBytes(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, fs.SetFile("empty", bytes.NewReader(nil)))
+	exists, err := fs.Exists("empty")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	stat, err := fs.Stat("empty")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, stat.Size())
+	value, err := fs.GetString("empty")
+	assert.NoError(t, err)
+	assert.Empty(t, value)
+}
+
+func TestSetFileSized(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int64
+		value string
+	}{
+		{"exact", 5, "value"},
+		{"unknown", -1, "value"},
+		// the preallocated tail is truncated away
+		{"larger", 1 << 20, "value"},
+		{"smaller", 2, "value"},
+		{"empty with size", 10, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := NewFileSystemBase(t.TempDir())
+			assert.NoError(t, fs.SetString("file", "a previous, longer value"))
+			assert.NoError(t, fs.SetFileSized("file", strings.NewReader(test.value), test.size))
+			stat, err := fs.Stat("file")
+			assert.NoError(t, err)
+			assert.EqualValues(t, len(test.value), stat.Size())
+			data, err := fs.GetBytes("file")
+			assert.NoError(t, err)
+			assert.Equal(t, test.value, string(data))
+		})
+	}
+}
+
+func TestValidateZip(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	buf := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(buf)
+	entry, err := zipWriter.Create("Payload/App.app/Info.plist")
+	assert.NoError(t, err)
+	_, err = entry.Write([]byte("plist"))
+	assert.NoError(t, err)
+	assert.NoError(t, zipWriter.Close())
+	valid := buf.Bytes()
+
+	assert.NoError(t, fs.SetFile("valid", bytes.NewReader(valid)))
+	assert.NoError(t, fs.ValidateZip("valid"))
+
+	assert.NoError(t, fs.SetFile("truncated", bytes.NewReader(valid[:len(valid)-10])))
+	assert.ErrorIs(t, fs.ValidateZip("truncated"), ErrInvalidZip)
+
+	badHeader := append([]byte{}, valid...)
+	copy(badHeader, "XXXX")
+	assert.NoError(t, fs.SetFile("bad header", bytes.NewReader(badHeader)))
+	assert.ErrorIs(t, fs.ValidateZip("bad header"), ErrInvalidZip)
+}
+
+func TestEnsureFile(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	calls := 0
+	generate := func() (io.ReadSeeker, error) {
+		calls++
+		return strings.NewReader("seed"), nil
+	}
+	created, err := fs.EnsureFile("config", generate)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	created, err = fs.EnsureFile("config", generate)
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, 1, calls)
+	value, err := fs.GetString("config")
+	assert.NoError(t, err)
+	assert.Equal(t, "seed", value)
+}
+
+func TestWriteStrategyFallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		strategy    WriteStrategy
+		fallback    bool
+		replaceErr  error
+		renameErr   error
+		wantErr     error
+		wantRenames bool
+	}{
+		{"cross-device", WriteReplace, true, syscall.EXDEV, nil, nil, false},
+		{"rename over existing unsupported", WriteReplace, true, syscall.ENOTSUP, nil, nil, true},
+		{"renames unsupported", WriteReplace, true, syscall.ENOTSUP, syscall.ENOTSUP, nil, true},
+		{"no fallback", WriteReplace, false, syscall.EXDEV, nil, syscall.EXDEV, false},
+		{"remove rename fails", WriteRemoveRename, false, nil, syscall.EIO, syscall.EIO, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			fs := NewFileSystemBase(root, WithWriteStrategy(test.strategy, test.fallback))
+			assert.NoError(t, fs.SetString("file", "old"))
+			fs.replaceFile = func(source string, target string) error {
+				if test.replaceErr != nil {
+					return &os.LinkError{Op: "rename", Old: source, New: target, Err: test.replaceErr}
+				}
+				return os.Rename(source, target)
+			}
+			renames := false
+			fs.rename = func(source string, target string) error {
+				renames = true
+				// moving the target aside and back works, committing the temp file does not
+				if test.renameErr != nil && !strings.HasSuffix(source, ".old") && isTempFileName(filepath.Base(source)) {
+					return &os.LinkError{Op: "rename", Old: source, New: target, Err: test.renameErr}
+				}
+				return os.Rename(source, target)
+			}
+			err := fs.SetString("file", "new")
+			assert.Equal(t, test.wantRenames, renames)
+			value, getErr := fs.GetString("file")
+			assert.NoError(t, getErr)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				assert.Equal(t, "old", value)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "new", value)
+			}
+			entries, err := os.ReadDir(root)
+			assert.NoError(t, err)
+			assert.Len(t, entries, 1)
+		})
+	}
+}
+
+func TestSetFileWhileOpen(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, fs.SetString("file", "old"))
+	reader, err := fs.GetFile("file")
+	assert.NoError(t, err)
+	// on Windows, the write has to wait until the reader is done
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		reader.Close()
+	}()
+	assert.NoError(t, fs.SetString("file", "new"))
+	value, err := fs.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", value)
+}
+
+func TestTempFileCleanup(t *testing.T) {
+	root := t.TempDir()
+	fs := NewFileSystemBase(root, WithTempNamer(NewSequentialTempNamer()))
+	assert.NoError(t, fs.SetString("file", "value"))
+	_, err := os.Stat(filepath.Join(root, ".file.tmp-1"))
+	assert.True(t, os.IsNotExist(err))
+
+	failingReader := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	assert.Error(t, fs.SetFile("file", failingReader))
+	_, err = os.Stat(filepath.Join(root, ".file.tmp-2"))
+	assert.True(t, os.IsNotExist(err))
+	value, err := fs.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func TestSequentialTempNamerSkipsLeftovers(t *testing.T) {
+	root := t.TempDir()
+	// left over by an earlier process, whose counter started at 0 as well
+	leftovers := []string{".file.tmp-1", ".file.tmp-2"}
+	for _, leftover := range leftovers {
+		writeTestFile(t, filepath.Join(root, leftover), "leftover")
+	}
+	fs := NewFileSystemBase(root, WithTempNamer(NewSequentialTempNamer()))
+	assert.NoError(t, fs.SetString("file", "value"))
+	value, err := fs.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	assert.Equal(t, append(leftovers, "file"), listCompactTree(t, root))
+
+	// a namer that only returns taken names gives up
+	fs = NewFileSystemBase(root, WithTempNamer(func(FSName) string {
+		return leftovers[0]
+	}))
+	assert.ErrorIs(t, fs.SetString("file", "new"), os.ErrExist)
+}
+
+type flakyReader struct {
+	io.Reader
+	errs  []error
+	reads int
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	r.reads++
+	if len(r.errs) > 0 {
+		err := r.errs[0]
+		r.errs = r.errs[1:]
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
+
+func TestTempDir(t *testing.T) {
+	tempDir := filepath.Join(t.TempDir(), "scratch")
+	fs := NewFileSystemBase(t.TempDir(), WithTempDir(tempDir, true))
+	var sources []string
+	fs.replaceFile = func(source string, target string) error {
+		sources = append(sources, source)
+		return os.Rename(source, target)
+	}
+	assert.NoError(t, fs.SetString("file", "value"))
+	assert.Len(t, sources, 1)
+	assert.Equal(t, tempDir, filepath.Dir(sources[0]))
+	value, err := fs.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	entries, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestTempDirNewRoot(t *testing.T) {
+	parent := t.TempDir()
+	tempDir := filepath.Join(parent, "scratch")
+	fs := NewFileSystemBase(filepath.Join(parent, "store"), WithTempDir(tempDir, true))
+	assert.NoError(t, fs.SetString("file", "value"))
+	value, err := fs.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	stat, err := os.Stat(tempDir)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), stat.Mode().Perm())
+}
+
+func TestTempDirCrossDevice(t *testing.T) {
+	tests := []struct {
+		name          string
+		requireAtomic bool
+	}{
+		{"copy", false},
+		{"require atomic", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			var buffer bytes.Buffer
+			logger := zerolog.New(&buffer)
+			// renames out of the temp dir fail like across devices, also while probing it
+			crossDevice := func(a *FileSystemBase) {
+				a.rename = func(source string, target string) error {
+					if strings.HasPrefix(source, tempDir) {
+						return &os.LinkError{Op: "rename", Old: source, New: target, Err: syscall.EXDEV}
+					}
+					return os.Rename(source, target)
+				}
+			}
+			// the logger comes last, and still gets the warning of the probe
+			fs := NewFileSystemBase(t.TempDir(), WithTempDir(tempDir, test.requireAtomic), crossDevice, WithLogger(&logger))
+			err := fs.SetString("file", "value")
+			if test.requireAtomic {
+				assert.ErrorIs(t, err, ErrTempDirNotAtomic)
+				return
+			}
+			assert.NoError(t, err)
+			value, err := fs.GetString("file")
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+			assert.True(t, fs.tempDirCopy)
+			entries := parseLogEntries(t, &buffer)
+			assert.NotEmpty(t, entries)
+			assert.Equal(t, "warn", entries[0].Level)
+			assert.Contains(t, entries[0].Error, syscall.EXDEV.Error())
+		})
+	}
+}
+
+func TestSetFileRetriesEINTR(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	reader := &flakyReader{Reader: strings.NewReader("value"), errs: []error{syscall.EINTR, syscall.EINTR}}
+	assert.NoError(t, fs.SetFile("file", reader))
+	value, err := fs.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	reader = &flakyReader{Reader: strings.NewReader("value"), errs: []error{syscall.ENOSPC}}
+	assert.ErrorIs(t, fs.SetFile("file", reader), syscall.ENOSPC)
+	assert.Equal(t, 1, reader.reads)
+
+	fs = NewFileSystemBase(t.TempDir(), WithIORetries(0))
+	reader = &flakyReader{Reader: strings.NewReader("value"), errs: []error{syscall.EINTR}}
+	assert.ErrorIs(t, fs.SetFile("file", reader), syscall.EINTR)
+}
+
+func TestContextLockTimeout(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, fs.SetString("file", "value"))
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		fs.mu.Lock()
+		close(locked)
+		<-release
+		fs.mu.Unlock()
+	}()
+	<-locked
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, fs.SetStringContext(ctx, "file", "other"), context.DeadlineExceeded)
+	_, err := fs.GetStringContext(ctx, "file")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	value, err := fs.GetStringContext(context.Background(), "file")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func TestCopyBufferSize(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	tests := []struct {
+		name string
+		size int
+		want int
+	}{
+		{"default", 0, defaultCopyBufferSize},
+		{"negative", -1, defaultCopyBufferSize},
+		{"small", 7, 7},
+		{"capped", 2 * maxCopyBufferSize, maxCopyBufferSize},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := NewFileSystemBase(t.TempDir(), WithCopyBufferSize(test.size))
+			assert.Equal(t, test.want, fs.copyBufferSizeOrDefault())
+			assert.NoError(t, fs.SetFile("file", bytes.NewReader(data)))
+			var buffer bytes.Buffer
+			written, err := fs.WriteTo("file", plainWriter{&buffer}, 5, 50000)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(50000), written)
+			assert.Equal(t, data[5:50005], buffer.Bytes())
+		})
+	}
+}
+
+func TestGetStringSizes(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	for _, size := range []int{0, 1, maxPooledBufferSize, maxPooledBufferSize + 1, 4 * maxPooledBufferSize} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			value := strings.Repeat("a", size)
+			assert.NoError(t, fs.SetFile("file", strings.NewReader(" "+value+"\n")))
+			// twice, so the second read may reuse a pooled buffer
+			for i := 0; i < 2; i++ {
+				read, err := fs.GetString("file")
+				assert.NoError(t, err)
+				assert.Equal(t, value, read)
+			}
+		})
+	}
+}
+
+func BenchmarkGetString(b *testing.B) {
+	fs := NewFileSystemBase(b.TempDir())
+	assert.NoError(b, fs.SetString("file", strings.Repeat("a", 4<<10)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.GetString("file"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The previous implementation of GetString, for comparison with BenchmarkGetString.
+func (a *FileSystemBase) readFile(filePath string) ([]byte, error) {
+	var f *os.File
+	err := a.retryTransient(func() error {
+		var err error
+		f, err = os.Open(filePath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(&retryReader{f, a})
+}
+
+func BenchmarkGetStringUnpooled(b *testing.B) {
+	fs := NewFileSystemBase(b.TempDir())
+	assert.NoError(b, fs.SetString("file", strings.Repeat("a", 4<<10)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := fs.readFile(fs.resolvePath("file"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = strings.TrimSpace(string(data))
+	}
+}
+
+func BenchmarkSetFileParallel(b *testing.B) {
+	fs := NewFileSystemBase(b.TempDir())
+	data := bytes.Repeat([]byte("a"), 1<<20)
+	counter := atomic.NewInt64(0)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		name := FSName(fmt.Sprint("file", counter.Inc()))
+		for pb.Next() {
+			if err := fs.SetFile(name, bytes.NewReader(data)); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// Hide io.ReaderFrom and io.WriterTo, so the copy buffer is used like with retryWriter.
+type plainWriter struct {
+	io.Writer
+}
+
+type plainReader struct {
+	io.Reader
+}
+
+func benchmarkCopyParallel(b *testing.B, copyFn func(io.Writer, io.Reader) (int64, error)) {
+	data := bytes.Repeat([]byte("a"), 1<<20)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := copyFn(plainWriter{io.Discard}, plainReader{bytes.NewReader(data)}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkCopyParallel(b *testing.B) {
+	benchmarkCopyParallel(b, copyBuffered)
+}
+
+// The previous implementation, for comparison with BenchmarkCopyParallel.
+func BenchmarkCopyParallelUnpooled(b *testing.B) {
+	benchmarkCopyParallel(b, io.Copy)
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tieredRoutesName = FSName(".tiers.json")
+
+type tier string
+
+const (
+	tierSmall = tier("small")
+	tierLarge = tier("large")
+)
+
+// Thresholds up to this are peeked in memory by SetFile, larger ones through a temp file.
+const tieredMemoryPeekLimit = 64 * 1024
+
+// Routes files up to threshold bytes to the small backend, and anything larger to the large one.
+// The names held by the large backend are recorded in the small backend so they survive
+// restarts, and the record is only rewritten when a name changes backend. Writes of the same
+// name are serialized, others run concurrently.
+type TieredFileSystem struct {
+	// guards routes and routesVersion
+	mu        sync.RWMutex
+	threshold int64
+	small     FileSystem
+	large     FileSystem
+	// names absent from it are in the small backend
+	routes        map[FSName]tier
+	routesVersion int64
+	saveMu        sync.Mutex
+	savedVersion  int64
+	names         nameLocks
+}
+
+func NewTieredFileSystem(threshold int64, small FileSystem, large FileSystem) (*TieredFileSystem, error) {
+	t := &TieredFileSystem{
+		threshold: threshold,
+		small:     small,
+		large:     large,
+		routes:    map[FSName]tier{},
+	}
+	data, err := small.GetString(tieredRoutesName)
+	if isNotFound(err) {
+		return t, nil
+	} else if err != nil {
+		return nil, errors.WithMessage(err, "get tier routes")
+	}
+	if err := json.Unmarshal([]byte(data), &t.routes); err != nil {
+		return nil, errors.WithMessage(err, "unmarshal tier routes")
+	}
+	// older versions also recorded the small names
+	for name, routed := range t.routes {
+		if routed != tierLarge {
+			delete(t.routes, name)
+		}
+	}
+	return t, nil
+}
+
+func (t *TieredFileSystem) backend(name FSName) FileSystem {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.routes[name] == tierLarge {
+		return t.large
+	}
+	return t.small
+}
+
+func (t *TieredFileSystem) GetString(name FSName) (string, error) {
+	return t.backend(name).GetString(name)
+}
+
+func (t *TieredFileSystem) GetBytes(name FSName) ([]byte, error) {
+	return t.backend(name).GetBytes(name)
+}
+
+func (t *TieredFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	return t.backend(name).GetFile(name)
+}
+
+func (t *TieredFileSystem) GetSeekableFile(name FSName) (io.ReadSeekCloser, int64, error) {
+	return t.backend(name).GetSeekableFile(name)
+}
+
+func (t *TieredFileSystem) WriteTo(name FSName, w io.Writer, offset int64, length int64) (int64, error) {
+	return t.backend(name).WriteTo(name, w, offset, length)
+}
+
+func (t *TieredFileSystem) StreamArchive(prefix FSName, format ArchiveFormat, w io.Writer) error {
+	return streamArchive(t, prefix, format, w)
+}
+
+func (t *TieredFileSystem) SetString(name FSName, value string) error {
+	return t.SetFile(name, strings.NewReader(value))
+}
+
+func (t *TieredFileSystem) SetFile(name FSName, value io.Reader) error {
+	// read one byte past the threshold to find out which side of it we are on
+	head, size, cleanup, err := spoolHead(value, t.threshold+1)
+	if err != nil {
+		return errors.WithMessage(err, "peek file")
+	}
+	defer cleanup()
+	return t.setFile(name, io.MultiReader(head, value), size)
+}
+
+// Like SetFile, but routes based on the given size instead of buffering the start of value.
+func (t *TieredFileSystem) SetFileSized(name FSName, value io.Reader, size int64) error {
+	return t.setFile(name, value, size)
+}
+
+// Reads up to n bytes of value, in memory if n is small and into a temp file otherwise.
+// Returns a reader of them, how many there were, and a func that frees them.
+func spoolHead(value io.Reader, n int64) (io.Reader, int64, func(), error) {
+	if n <= tieredMemoryPeekLimit {
+		var buffer bytes.Buffer
+		read, err := buffer.ReadFrom(io.LimitReader(value, n))
+		return &buffer, read, func() {}, err
+	}
+	f, err := os.CreateTemp("", tempFilePattern("tiered"))
+	if err != nil {
+		return nil, 0, nil, errors.WithMessage(err, "create temp file")
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	read, err := copyBuffered(f, io.LimitReader(value, n))
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return f, read, cleanup, nil
+}
+
+func (t *TieredFileSystem) setFile(name FSName, value io.Reader, size int64) error {
+	defer t.names.lock(string(name))()
+	newTier, target, other := tierSmall, t.small, t.large
+	if size > t.threshold {
+		newTier, target, other = tierLarge, t.large, t.small
+	}
+	if err := target.SetFile(name, value); err != nil {
+		return err
+	}
+	if t.tier(name) == newTier {
+		return nil
+	}
+	if err := t.setRoute(name, newTier); err != nil {
+		return err
+	}
+	if err := other.RemoveFileIfExists(name); err != nil {
+		return errors.WithMessagef(err, "remove %s from old tier", name)
+	}
+	return nil
+}
+
+func (t *TieredFileSystem) tier(name FSName) tier {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.routes[name] == tierLarge {
+		return tierLarge
+	}
+	return tierSmall
+}
+
+func (t *TieredFileSystem) setRoute(name FSName, newTier tier) error {
+	t.mu.Lock()
+	if newTier == tierLarge {
+		t.routes[name] = tierLarge
+	} else {
+		delete(t.routes, name)
+	}
+	t.routesVersion++
+	version := t.routesVersion
+	t.mu.Unlock()
+	return t.saveRoutes(version)
+}
+
+// Saves the routes as of at least version. Saves are serialized, and skipped if a concurrent
+// one already covered the change, so a burst of route changes becomes few rewrites.
+func (t *TieredFileSystem) saveRoutes(version int64) error {
+	t.saveMu.Lock()
+	defer t.saveMu.Unlock()
+	if t.savedVersion >= version {
+		return nil
+	}
+	t.mu.RLock()
+	data, err := json.Marshal(t.routes)
+	current := t.routesVersion
+	t.mu.RUnlock()
+	if err != nil {
+		return errors.WithMessage(err, "marshal tier routes")
+	}
+	if err := t.small.SetFile(tieredRoutesName, bytes.NewReader(data)); err != nil {
+		return errors.WithMessage(err, "save tier routes")
+	}
+	t.savedVersion = current
+	return nil
+}
+
+func (t *TieredFileSystem) RemoveFile(name FSName) error {
+	defer t.names.lock(string(name))()
+	if err := t.backend(name).RemoveFile(name); err != nil {
+		return err
+	}
+	if t.tier(name) == tierSmall {
+		return nil
+	}
+	return t.setRoute(name, tierSmall)
+}
+
+func (t *TieredFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(t, name)
+}
+
+func (t *TieredFileSystem) Stat(name FSName) (os.FileInfo, error) {
+	return t.backend(name).Stat(name)
+}
+
+func (t *TieredFileSystem) MkDir(name FSName) error {
+	if err := t.small.MkDir(name); err != nil {
+		return err
+	}
+	return t.large.MkDir(name)
+}
+
+func (t *TieredFileSystem) ReadDir(name FSName) ([]os.DirEntry, error) {
+	smallEntries, smallErr := t.small.ReadDir(name)
+	if smallErr != nil && !isNotFound(smallErr) {
+		return nil, smallErr
+	}
+	largeEntries, largeErr := t.large.ReadDir(name)
+	if largeErr != nil && !isNotFound(largeErr) {
+		return nil, largeErr
+	}
+	if smallErr != nil && largeErr != nil {
+		return nil, smallErr
+	}
+	seen := map[string]bool{}
+	var entries []os.DirEntry
+	for _, entry := range append(smallEntries, largeEntries...) {
+		if !seen[entry.Name()] {
+			seen[entry.Name()] = true
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (t *TieredFileSystem) ListFiles(prefix FSName) ([]FSName, error) {
+	return mergeFileLists(func(fs FileSystem) ([]FSName, error) {
+		return fs.ListFiles(prefix)
+	}, t.small, t.large)
+}
+
+func (t *TieredFileSystem) ListModifiedSince(prefix FSName, since time.Time) ([]FSName, error) {
+	return mergeFileLists(func(fs FileSystem) ([]FSName, error) {
+		return fs.ListModifiedSince(prefix, since)
+	}, t.small, t.large)
+}
+
+func (t *TieredFileSystem) TreeHash(prefix FSName) (string, error) {
+	return treeHash(t, prefix)
+}
+
+// Calls list on every FileSystem and returns the sorted union of the names.
+// A missing prefix on some of them is not an error, as long as one of them has it.
+func mergeFileLists(list func(FileSystem) ([]FSName, error), fileSystems ...FileSystem) ([]FSName, error) {
+	seen := map[FSName]bool{}
+	var names []FSName
+	var firstErr error
+	found := false
+	for _, fs := range fileSystems {
+		fsNames, err := list(fs)
+		if isNotFound(err) {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, name := range fsNames {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if !found {
+		return nil, firstErr
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+	return names, nil
+}
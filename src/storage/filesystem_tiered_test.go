@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestTieredFileSystem(t *testing.T, threshold int64) (*TieredFileSystem, *FileSystemBase, *FileSystemBase) {
+	small := NewFileSystemBase(t.TempDir())
+	large := NewFileSystemBase(t.TempDir())
+	tiered, err := NewTieredFileSystem(threshold, small, large)
+	assert.NoError(t, err)
+	return tiered, small, large
+}
+
+func assertTier(t *testing.T, fs *FileSystemBase, name FSName, want bool) {
+	exists, err := fs.Exists(name)
+	assert.NoError(t, err)
+	assert.Equal(t, want, exists)
+}
+
+func TestTieredRouting(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int64
+		size      int
+		sized     bool
+		large     bool
+	}{
+		{"empty", 10, 0, false, false},
+		{"below", 10, 9, false, false},
+		{"at threshold", 10, 10, false, false},
+		{"above", 10, 11, false, true},
+		{"sized below", 10, 5, true, false},
+		{"sized above", 10, 50, true, true},
+		{"spooled below", 2 * tieredMemoryPeekLimit, 2 * tieredMemoryPeekLimit, false, false},
+		{"spooled above", 2 * tieredMemoryPeekLimit, 3 * tieredMemoryPeekLimit, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tiered, small, large := newTestTieredFileSystem(t, test.threshold)
+			value := strings.Repeat("a", test.size)
+			if test.sized {
+				assert.NoError(t, tiered.SetFileSized("file", strings.NewReader(value), int64(test.size)))
+			} else {
+				assert.NoError(t, tiered.SetFile("file", strings.NewReader(value)))
+			}
+			assertTier(t, large, "file", test.large)
+			assertTier(t, small, "file", !test.large)
+			data, err := tiered.GetBytes("file")
+			assert.NoError(t, err)
+			assert.Equal(t, value, string(data))
+		})
+	}
+}
+
+func TestTieredMovesBetweenTiers(t *testing.T) {
+	tiered, small, large := newTestTieredFileSystem(t, 4)
+	assert.NoError(t, tiered.SetString("file", "large value"))
+	assertTier(t, large, "file", true)
+
+	// the route survives a restart
+	restarted, err := NewTieredFileSystem(4, small, large)
+	assert.NoError(t, err)
+	value, err := restarted.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "large value", value)
+
+	assert.NoError(t, restarted.SetString("file", "abc"))
+	assertTier(t, large, "file", false)
+	assertTier(t, small, "file", true)
+	restarted, err = NewTieredFileSystem(4, small, large)
+	assert.NoError(t, err)
+	value, err = restarted.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", value)
+}
+
+func TestTieredRemoveFile(t *testing.T) {
+	tiered, small, large := newTestTieredFileSystem(t, 4)
+	assert.NoError(t, tiered.SetString("file", "large value"))
+	assert.NoError(t, tiered.RemoveFile("file"))
+	assertTier(t, large, "file", false)
+	assert.True(t, isNotFound(tiered.RemoveFile("file")))
+
+	restarted, err := NewTieredFileSystem(4, small, large)
+	assert.NoError(t, err)
+	assert.NoError(t, restarted.SetString("file", "abc"))
+	assertTier(t, small, "file", true)
+}
+
+func TestTieredLegacyRoutes(t *testing.T) {
+	small := NewFileSystemBase(t.TempDir())
+	large := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, small.SetString(tieredRoutesName, `{"a":"small","b":"large"}`))
+	assert.NoError(t, small.SetString("a", "a"))
+	assert.NoError(t, large.SetString("b", "b"))
+	tiered, err := NewTieredFileSystem(4, small, large)
+	assert.NoError(t, err)
+	for _, name := range []FSName{"a", "b"} {
+		value, err := tiered.GetString(name)
+		assert.NoError(t, err)
+		assert.Equal(t, string(name), value)
+	}
+}
+
+func TestTieredConcurrentWritesSameName(t *testing.T) {
+	tiered, small, large := newTestTieredFileSystem(t, 4)
+	values := []string{"abc", "large value"}
+	for round := 0; round < 10; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(value string) {
+				defer wg.Done()
+				assert.NoError(t, tiered.SetFile("file", bytes.NewReader([]byte(value))))
+			}(values[i%2])
+		}
+		wg.Wait()
+		value, err := tiered.GetString("file")
+		assert.NoError(t, err)
+		isLarge := value == values[1]
+		// the other tier must not keep a stale copy
+		assertTier(t, large, "file", isLarge)
+		assertTier(t, small, "file", !isLarge)
+	}
+}
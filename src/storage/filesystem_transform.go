@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"os"
+)
+
+// Streams the file through fn into a temp file, then atomically replaces the original.
+// If fn fails, the original is left untouched. Writes to the same name that happen while
+// the transform is running are overwritten.
+func (a *FileSystemBase) Transform(name FSName, fn func(r io.Reader, w io.Writer) error) error {
+	source, err := a.GetFile(name)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writer.CloseWithError(fn(source, writer))
+	}()
+	tempPath, err := a.writeTempFile(name, reader, -1)
+	// unblock fn if the temp file failed first, and wait for it to stop reading source
+	reader.CloseWithError(errors.New("transform aborted"))
+	<-done
+	if err != nil {
+		return errors.WithMessage(err, "transform file")
+	}
+	defer os.Remove(tempPath)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.commitTempFile(tempPath, a.resolveWritePath(name)); err != nil {
+		return errors.WithMessage(err, "replace file")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func upperTransform(r io.Reader, w io.Writer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bytes.ToUpper(data))
+	return err
+}
+
+func TestTransform(t *testing.T) {
+	errTransform := errors.New("transform failed")
+	tests := []struct {
+		name    string
+		file    FSName
+		opts    []FileSystemOption
+		fn      func(r io.Reader, w io.Writer) error
+		want    string
+		wantErr error
+	}{
+		{"upper", "file", nil, upperTransform, "VALUE", nil},
+		{"long name", FSName(strings.Repeat("a", 300)), []FileSystemOption{WithLongNameHashing()}, upperTransform, "VALUE", nil},
+		{"fn fails", "file", nil, func(r io.Reader, w io.Writer) error {
+			if _, err := w.Write([]byte("partial")); err != nil {
+				return err
+			}
+			return errTransform
+		}, "value", errTransform},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := NewFileSystemBase(t.TempDir(), test.opts...)
+			assert.NoError(t, fs.SetString(test.file, "value"))
+			err := fs.Transform(test.file, test.fn)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			value, err := fs.GetString(test.file)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, value)
+			names, err := fs.ListFiles("")
+			assert.NoError(t, err)
+			assert.Equal(t, []FSName{test.file}, names)
+		})
+	}
+}
+
+func TestTransformTempFileFails(t *testing.T) {
+	root := t.TempDir()
+	blocked := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(blocked, nil, 0600))
+	writeTestFile(t, filepath.Join(root, "file"), "value")
+	// the temp dir cannot be created below a file
+	fs := NewFileSystemBase(root, WithTempDir(filepath.Join(blocked, "temp"), false))
+
+	var fnDone bool
+	err := fs.Transform("file", func(r io.Reader, w io.Writer) error {
+		_, err := w.Write([]byte("new"))
+		// still running after the write failed
+		time.Sleep(50 * time.Millisecond)
+		fnDone = true
+		return err
+	})
+	assert.Error(t, err)
+	assert.True(t, fnDone, "Transform returned before fn")
+	value, err := fs.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func TestTransformMissing(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	err := fs.Transform("missing", upperTransform)
+	assert.True(t, isNotFound(err))
+}
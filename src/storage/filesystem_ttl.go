@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"time"
+)
+
+// Expires files written with a TTL. The expiry time of each such file is kept in a hidden
+// sidecar next to it, and PurgeExpired removes the files that are past it. Files written
+// without a TTL never expire.
+type TTLFileSystem struct {
+	FileSystem
+	// held per name while its expiry is changed, or checked and acted on by PurgeExpired
+	names nameLocks
+}
+
+func NewTTLFileSystem(fs FileSystem) *TTLFileSystem {
+	return &TTLFileSystem{FileSystem: fs}
+}
+
+func expiryName(name FSName) FSName {
+	return sidecarName(name, "expires")
+}
+
+// Like SetFile, but the file expires after ttl.
+func (t *TTLFileSystem) SetFileWithTTL(name FSName, value io.Reader, ttl time.Duration) error {
+	defer t.names.lock(string(name))()
+	if err := t.FileSystem.SetFile(name, value); err != nil {
+		return err
+	}
+	return t.setExpiry(name, time.Now().Add(ttl))
+}
+
+func (t *TTLFileSystem) setExpiry(name FSName, expiry time.Time) error {
+	if err := t.FileSystem.SetString(expiryName(name), expiry.UTC().Format(time.RFC3339Nano)); err != nil {
+		return errors.WithMessage(err, "save expiry")
+	}
+	return nil
+}
+
+// Returns the expiry time of name and true, or false if it never expires.
+func (t *TTLFileSystem) GetExpiry(name FSName) (time.Time, bool, error) {
+	value, err := t.FileSystem.GetString(expiryName(name))
+	if isNotFound(err) {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, errors.WithMessage(err, "get expiry")
+	}
+	expiry, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false, errors.WithMessage(err, "parse expiry")
+	}
+	return expiry, true, nil
+}
+
+// Moves the expiry of name to ttl from now without rewriting the file, e.g. when a download
+// of it starts, so PurgeExpired does not remove it mid-transfer. Fails with ErrNotFound if
+// name does not exist or was written without a TTL.
+func (t *TTLFileSystem) ExtendTTL(name FSName, ttl time.Duration) error {
+	defer t.names.lock(string(name))()
+	if _, err := t.FileSystem.Stat(name); isNotFound(err) {
+		return errors.WithMessagef(ErrNotFound, "file %s", name)
+	} else if err != nil {
+		return err
+	}
+	if _, ok, err := t.GetExpiry(name); err != nil {
+		return err
+	} else if !ok {
+		return errors.WithMessagef(ErrNotFound, "expiry of %s", name)
+	}
+	return t.setExpiry(name, time.Now().Add(ttl))
+}
+
+// Writing without a TTL makes the file permanent.
+func (t *TTLFileSystem) SetString(name FSName, value string) error {
+	defer t.names.lock(string(name))()
+	if err := t.FileSystem.SetString(name, value); err != nil {
+		return err
+	}
+	return t.clearExpiry(name)
+}
+
+func (t *TTLFileSystem) SetFile(name FSName, value io.Reader) error {
+	defer t.names.lock(string(name))()
+	if err := t.FileSystem.SetFile(name, value); err != nil {
+		return err
+	}
+	return t.clearExpiry(name)
+}
+
+func (t *TTLFileSystem) RemoveFile(name FSName) error {
+	defer t.names.lock(string(name))()
+	if err := t.FileSystem.RemoveFile(name); err != nil {
+		return err
+	}
+	return t.clearExpiry(name)
+}
+
+func (t *TTLFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(t, name)
+}
+
+func (t *TTLFileSystem) clearExpiry(name FSName) error {
+	if err := t.FileSystem.RemoveFileIfExists(expiryName(name)); err != nil {
+		return errors.WithMessage(err, "remove expiry")
+	}
+	return nil
+}
+
+// Removes every expired file under prefix and returns the removed names. The expiry is
+// checked again under the same lock as ExtendTTL right before removing, so a file whose
+// TTL was extended in the meantime is kept.
+func (t *TTLFileSystem) PurgeExpired(prefix FSName) ([]FSName, error) {
+	names, err := t.FileSystem.ListFiles(prefix)
+	if err != nil {
+		return nil, errors.WithMessage(err, "list files")
+	}
+	var purged []FSName
+	for _, name := range names {
+		// only lock the files that look expired
+		if expired, err := t.isExpired(name); err != nil {
+			return purged, errors.WithMessagef(err, "purge %s", name)
+		} else if !expired {
+			continue
+		}
+		removed, err := t.purgeIfExpired(name)
+		if err != nil {
+			return purged, errors.WithMessagef(err, "purge %s", name)
+		}
+		if removed {
+			purged = append(purged, name)
+		}
+	}
+	return purged, nil
+}
+
+func (t *TTLFileSystem) isExpired(name FSName) (bool, error) {
+	expiry, ok, err := t.GetExpiry(name)
+	if err != nil {
+		return false, err
+	}
+	return ok && !expiry.After(time.Now()), nil
+}
+
+func (t *TTLFileSystem) purgeIfExpired(name FSName) (bool, error) {
+	defer t.names.lock(string(name))()
+	if expired, err := t.isExpired(name); err != nil || !expired {
+		return false, err
+	}
+	if err := t.FileSystem.RemoveFileIfExists(name); err != nil {
+		return false, err
+	}
+	return true, t.clearExpiry(name)
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTTLPurgeExpired(t *testing.T) {
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		purged bool
+	}{
+		{"expired", -time.Second, true},
+		{"alive", time.Hour, false},
+		{"permanent", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := NewTTLFileSystem(NewFileSystemBase(t.TempDir()))
+			if test.ttl == 0 {
+				assert.NoError(t, fs.SetString("file", "value"))
+			} else {
+				assert.NoError(t, fs.SetFileWithTTL("file", strings.NewReader("value"), test.ttl))
+			}
+			purged, err := fs.PurgeExpired("")
+			assert.NoError(t, err)
+			exists, err := fs.FileSystem.(*FileSystemBase).Exists("file")
+			assert.NoError(t, err)
+			if test.purged {
+				assert.Equal(t, []FSName{"file"}, purged)
+				assert.False(t, exists)
+				_, ok, err := fs.GetExpiry("file")
+				assert.NoError(t, err)
+				assert.False(t, ok)
+			} else {
+				assert.Empty(t, purged)
+				assert.True(t, exists)
+			}
+		})
+	}
+}
+
+func TestTTLWriteWithoutTTLClearsExpiry(t *testing.T) {
+	fs := NewTTLFileSystem(NewFileSystemBase(t.TempDir()))
+	assert.NoError(t, fs.SetFileWithTTL("file", strings.NewReader("value"), -time.Second))
+	assert.NoError(t, fs.SetString("file", "value"))
+	purged, err := fs.PurgeExpired("")
+	assert.NoError(t, err)
+	assert.Empty(t, purged)
+}
+
+func TestTTLExtendMissing(t *testing.T) {
+	fs := NewTTLFileSystem(NewFileSystemBase(t.TempDir()))
+	assert.ErrorIs(t, fs.ExtendTTL("missing", time.Hour), ErrNotFound)
+	assert.NoError(t, fs.SetString("permanent", "value"))
+	assert.ErrorIs(t, fs.ExtendTTL("permanent", time.Hour), ErrNotFound)
+}
+
+// Calls hook once, the first time the expiry of a file is read.
+type expiryHookFileSystem struct {
+	FileSystem
+	hook func()
+}
+
+func (h *expiryHookFileSystem) GetString(name FSName) (string, error) {
+	value, err := h.FileSystem.GetString(name)
+	if hook := h.hook; hook != nil && strings.HasSuffix(string(name), ".expires") {
+		h.hook = nil
+		hook()
+	}
+	return value, err
+}
+
+func TestTTLExtendDuringPurge(t *testing.T) {
+	hooked := &expiryHookFileSystem{FileSystem: NewFileSystemBase(t.TempDir())}
+	fs := NewTTLFileSystem(hooked)
+	assert.NoError(t, fs.SetFileWithTTL("file", strings.NewReader("value"), -time.Second))
+	extendErr := make(chan error, 1)
+	hooked.hook = func() {
+		// a download extends the TTL after the purge read the old expiry
+		go func() {
+			extendErr <- fs.ExtendTTL("file", time.Hour)
+		}()
+		select {
+		case err := <-extendErr:
+			extendErr <- err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	purged, err := fs.PurgeExpired("")
+	assert.NoError(t, err)
+	err = <-extendErr
+	exists, existsErr := hooked.FileSystem.(*FileSystemBase).Exists("file")
+	assert.NoError(t, existsErr)
+	// either the extension wins and the file is kept, or the purge wins and the extension fails
+	if err == nil {
+		assert.True(t, exists)
+		assert.Empty(t, purged)
+	} else {
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.False(t, exists)
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io"
+	"path"
+	"strings"
+)
+
+var ErrInvalidContent = errors.New("invalid content")
+
+// Inspects the first bytes of a file and reports whether they match the expected type.
+// The header may be shorter than requested if the file itself is shorter.
+type ContentSniffer func(header []byte) bool
+
+// Rejects writes whose content does not match the sniffer registered for the file extension.
+// Files with no registered sniffer are written as-is.
+type ValidatingFileSystem struct {
+	FileSystem
+	headerSize int
+	sniffers   map[string]ContentSniffer
+}
+
+// The sniffers map is keyed by extension, including the dot, e.g. ".ipa".
+func NewValidatingFileSystem(fs FileSystem, headerSize int, sniffers map[string]ContentSniffer) *ValidatingFileSystem {
+	return &ValidatingFileSystem{FileSystem: fs, headerSize: headerSize, sniffers: sniffers}
+}
+
+func (v *ValidatingFileSystem) SetString(name FSName, value string) error {
+	return v.SetFile(name, strings.NewReader(value))
+}
+
+func (v *ValidatingFileSystem) SetFile(name FSName, value io.Reader) error {
+	sniffer, ok := v.sniffers[strings.ToLower(path.Ext(string(name)))]
+	if !ok {
+		return v.FileSystem.SetFile(name, value)
+	}
+	header := make([]byte, v.headerSize)
+	n, err := io.ReadFull(value, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.WithMessage(err, "read header")
+	}
+	header = header[:n]
+	if !sniffer(header) {
+		return errors.WithMessagef(ErrInvalidContent, "%s", name)
+	}
+	return v.FileSystem.SetFile(name, io.MultiReader(bytes.NewReader(header), value))
+}
+
+// Matches zip archives, including .ipa files.
+func ZipSniffer(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06"))
+}
+
+// Matches DER-encoded PKCS#12 files: a SEQUENCE whose first element is the version INTEGER 3.
+func PKCS12Sniffer(header []byte) bool {
+	if len(header) < 2 || header[0] != 0x30 {
+		return false
+	}
+	offset := 2
+	if header[1]&0x80 != 0 {
+		offset += int(header[1] & 0x7F)
+	}
+	if len(header) < offset {
+		return false
+	}
+	return bytes.HasPrefix(header[offset:], []byte{0x02, 0x01, 0x03})
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestValidatingFileSystem(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    FSName
+		value   string
+		invalid bool
+	}{
+		{"zip", "app.ipa", "PK\x03\x04rest of the archive", false},
+		{"empty zip", "app.ipa", "PK\x05\x06", false},
+		{"upper case extension", "app.IPA", "PK\x03\x04rest", false},
+		{"not a zip", "app.ipa", "<html>", true},
+		{"empty", "app.ipa", "", true},
+		{"short form pkcs12", "cert.p12", "\x30\x0a\x02\x01\x03rest", false},
+		{"long form pkcs12", "cert.p12", "\x30\x82\x0a\x0b\x02\x01\x03rest", false},
+		{"wrong pkcs12 version", "cert.p12", "\x30\x0a\x02\x01\x02rest", true},
+		{"truncated pkcs12", "cert.p12", "\x30\x84\x00", true},
+		{"no sniffer", "notes.txt", "anything", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := NewFileSystemBase(t.TempDir())
+			v := NewValidatingFileSystem(base, 16, map[string]ContentSniffer{
+				".ipa": ZipSniffer,
+				".p12": PKCS12Sniffer,
+			})
+			err := v.SetFile(test.file, strings.NewReader(test.value))
+			if test.invalid {
+				assert.ErrorIs(t, err, ErrInvalidContent)
+				exists, err := base.Exists(test.file)
+				assert.NoError(t, err)
+				assert.False(t, exists)
+				return
+			}
+			assert.NoError(t, err)
+			// the sniffed header must be written along with the rest
+			data, err := base.GetBytes(test.file)
+			assert.NoError(t, err)
+			assert.Equal(t, test.value, string(data))
+		})
+	}
+}
@@ -0,0 +1,484 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const davPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/><d:getlastmodified/><d:resourcetype/></d:prop></d:propfind>`
+
+// Stores files on a WebDAV server, such as a Nextcloud or Synology NAS. Parent collections
+// are created as needed when writing, and missing files fail with ErrNotFound. To keep a
+// local copy as well, combine it with a local FileSystem in a MirrorFileSystem.
+type WebDAVFileSystem struct {
+	client   *http.Client
+	baseURL  *url.URL
+	username string
+	password string
+	// the paths of collections that were created or found by MkDir, so writes skip creating
+	// their parents again
+	collections sync.Map
+}
+
+// Requests fail if the server does not start responding within this long. There is no limit
+// on the whole request, since transfers of large files take as long as they take.
+const webdavResponseTimeout = time.Minute
+
+type WebDAVOption func(*WebDAVFileSystem)
+
+// Sends the requests with client instead of one with webdavResponseTimeout, e.g. to set
+// other timeouts, a proxy or TLS settings.
+func WithWebDAVClient(client *http.Client) WebDAVOption {
+	return func(w *WebDAVFileSystem) {
+		w.client = client
+	}
+}
+
+// Credentials are sent with basic auth, leave them empty if the server needs none.
+func NewWebDAVFileSystem(baseURL string, username string, password string, opts ...WebDAVOption) (*WebDAVFileSystem, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse base url")
+	}
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = webdavResponseTimeout
+	w := &WebDAVFileSystem{
+		client:   &http.Client{Transport: transport},
+		baseURL:  parsed,
+		username: username,
+		password: password,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w, nil
+}
+
+func (w *WebDAVFileSystem) url(name FSName) string {
+	resolved := *w.baseURL
+	resolved.Path += path.Clean("/" + string(name))
+	return resolved.String()
+}
+
+// Besides 2xx, the statuses in accepted are treated as success.
+func (w *WebDAVFileSystem) do(method string, name FSName, body io.Reader, header http.Header, accepted ...int) (*http.Response, error) {
+	return w.doContext(context.Background(), method, name, body, header, accepted...)
+}
+
+// Like do, but the request is canceled when ctx is done.
+func (w *WebDAVFileSystem) doContext(ctx context.Context, method string, name FSName, body io.Reader, header http.Header, accepted ...int) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, w.url(name), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	// the client only sends the length of bodies it can measure itself
+	if length := header.Get("Content-Length"); length != "" {
+		request.Header.Del("Content-Length")
+		if request.ContentLength, err = strconv.ParseInt(length, 10, 64); err != nil {
+			return nil, errors.WithMessage(err, "parse content length")
+		}
+	}
+	if w.username != "" || w.password != "" {
+		request.SetBasicAuth(w.username, w.password)
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+	for _, status := range accepted {
+		if response.StatusCode == status {
+			return response, nil
+		}
+	}
+	response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, errors.WithMessagef(ErrNotFound, "%s %s", method, name)
+	}
+	return nil, errors.Errorf("%s %s: %s", method, name, response.Status)
+}
+
+func (w *WebDAVFileSystem) GetString(name FSName) (string, error) {
+	data, err := w.GetBytes(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (w *WebDAVFileSystem) GetBytes(name FSName) ([]byte, error) {
+	response, err := w.do(http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return io.ReadAll(response.Body)
+}
+
+// Downloads the file into a local temp file, which is removed when the returned file is closed.
+func (w *WebDAVFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	response, err := w.do(http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	f, err := os.CreateTemp("", tempFilePattern(path.Base(string(name))))
+	if err != nil {
+		return nil, errors.WithMessage(err, "create temp file")
+	}
+	size, err := io.Copy(f, response.Body)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, errors.WithMessage(err, "download file")
+	}
+	modTime, _ := http.ParseTime(response.Header.Get("Last-Modified"))
+	return &webdavFile{File: f, info: &fileInfo{name: path.Base(string(name)), size: size, modTime: modTime}}, nil
+}
+
+// Streams the file without downloading it first. When the connection breaks mid-stream, the
+// file is requested again from the last read offset with a range request, up to 3 times in a
+// row. If the server cannot resume there, or the file changed in between, reads fail with
+// ErrResumeFailed.
+func (w *WebDAVFileSystem) GetFileResilient(name FSName) (io.ReadCloser, error) {
+	var validator string
+	return newResilientReader(func(offset int64) (io.ReadCloser, error) {
+		header := http.Header{}
+		if offset > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			if validator != "" {
+				header.Set("If-Range", validator)
+			}
+		}
+		response, err := w.do(http.MethodGet, name, nil, header)
+		if err != nil {
+			return nil, err
+		}
+		if offset == 0 {
+			validator = response.Header.Get("ETag")
+			if validator == "" {
+				validator = response.Header.Get("Last-Modified")
+			}
+			return response.Body, nil
+		}
+		if response.StatusCode != http.StatusPartialContent || validator == "" {
+			response.Body.Close()
+			return nil, errors.WithMessagef(ErrResumeFailed, "%s: %s", name, response.Status)
+		}
+		return response.Body, nil
+	})
+}
+
+type webdavFile struct {
+	*os.File
+	info os.FileInfo
+}
+
+func (f *webdavFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *webdavFile) Close() error {
+	defer os.Remove(f.File.Name())
+	return f.File.Close()
+}
+
+func (w *WebDAVFileSystem) GetSeekableFile(name FSName) (io.ReadSeekCloser, int64, error) {
+	file, err := w.GetFile(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, stat.Size(), nil
+}
+
+// Requests only the range from the server.
+func (w *WebDAVFileSystem) WriteTo(name FSName, writer io.Writer, offset int64, length int64) (int64, error) {
+	if length == 0 {
+		return 0, nil
+	}
+	header := http.Header{}
+	if offset > 0 || length > 0 {
+		rangeEnd := ""
+		if length > 0 {
+			rangeEnd = fmt.Sprint(offset + length - 1)
+		}
+		header.Set("Range", fmt.Sprintf("bytes=%d-%s", offset, rangeEnd))
+	}
+	response, err := w.do(http.MethodGet, name, nil, header)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	// servers may ignore the range and send everything
+	if response.StatusCode != http.StatusPartialContent && offset > 0 {
+		if _, err := io.CopyN(io.Discard, response.Body, offset); err != nil {
+			return 0, err
+		}
+	}
+	if length < 0 {
+		return copyBuffered(writer, response.Body)
+	}
+	return copyBufferedN(writer, response.Body, length)
+}
+
+func (w *WebDAVFileSystem) StreamArchive(prefix FSName, format ArchiveFormat, writer io.Writer) error {
+	return streamArchive(w, prefix, format, writer)
+}
+
+// Returns a random access reader of the file along with its size, without downloading it.
+// Reads fetch blockSize bytes at a time with ranged GETs, and the last maxBlocks blocks
+// are cached, which makes it practical to inspect remote archives with zip.NewReader.
+func (w *WebDAVFileSystem) GetReaderAt(name FSName, blockSize int64, maxBlocks int) (io.ReaderAt, int64, error) {
+	stat, err := w.Stat(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	reader, err := newBlockCacheReaderAt(&webdavRangeReader{fs: w, name: name}, stat.Size(), blockSize, maxBlocks)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reader, stat.Size(), nil
+}
+
+type webdavRangeReader struct {
+	fs   *WebDAVFileSystem
+	name FSName
+}
+
+func (r *webdavRangeReader) ReadAt(p []byte, off int64) (int, error) {
+	var buffer bytes.Buffer
+	buffer.Grow(len(p))
+	written, err := r.fs.WriteTo(r.name, &buffer, off, int64(len(p)))
+	copy(p, buffer.Bytes())
+	if err == nil && written < int64(len(p)) {
+		err = io.EOF
+	}
+	return int(written), err
+}
+
+func (w *WebDAVFileSystem) SetString(name FSName, value string) error {
+	return w.SetFile(name, strings.NewReader(strings.TrimSpace(value)))
+}
+
+func (w *WebDAVFileSystem) SetFile(name FSName, value io.Reader) error {
+	return w.put(context.Background(), name, value, nil)
+}
+
+// Uploads the file after creating the parent collections that are not known to exist.
+func (w *WebDAVFileSystem) put(ctx context.Context, name FSName, value io.Reader, header http.Header) error {
+	parent := FSName(path.Dir(path.Clean("/" + string(name))))
+	if err := w.MkDir(parent); err != nil {
+		return errors.WithMessage(err, "create parent collections")
+	}
+	response, err := w.doContext(ctx, http.MethodPut, name, value, header)
+	if err != nil {
+		// the parents may have been removed by another client, so create them again next time
+		for _, collection := range collectionPaths(parent) {
+			w.collections.Delete(collection)
+		}
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (w *WebDAVFileSystem) RemoveFile(name FSName) error {
+	response, err := w.do(http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (w *WebDAVFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(w, name)
+}
+
+func (w *WebDAVFileSystem) Stat(name FSName) (os.FileInfo, error) {
+	entries, err := w.propfind(name, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.WithMessagef(ErrNotFound, "PROPFIND %s", name)
+	}
+	return entries[0].info, nil
+}
+
+// Creates the collection along with missing parents. Collections that were created or found
+// before are skipped, unless a write into them failed since.
+func (w *WebDAVFileSystem) MkDir(name FSName) error {
+	for _, collection := range collectionPaths(name) {
+		if _, ok := w.collections.Load(collection); ok {
+			continue
+		}
+		// 405 means the collection already exists
+		response, err := w.do("MKCOL", FSName(collection), nil, nil, http.StatusMethodNotAllowed)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		w.collections.Store(collection, struct{}{})
+	}
+	return nil
+}
+
+// Returns the paths of name and its parents, outermost first, e.g. "a" and "a/b" for "/a/b".
+func collectionPaths(name FSName) []string {
+	var paths []string
+	current := ""
+	for _, component := range strings.Split(path.Clean("/"+string(name)), "/") {
+		if component == "" {
+			continue
+		}
+		current = path.Join(current, component)
+		paths = append(paths, current)
+	}
+	return paths
+}
+
+func (w *WebDAVFileSystem) ReadDir(name FSName) ([]os.DirEntry, error) {
+	entries, err := w.propfind(name, "1")
+	if err != nil {
+		return nil, err
+	}
+	var dirEntries []os.DirEntry
+	for _, entry := range entries {
+		if entry.self || strings.HasPrefix(entry.info.Name(), ".") {
+			continue
+		}
+		dirEntries = append(dirEntries, fs.FileInfoToDirEntry(entry.info))
+	}
+	sort.Slice(dirEntries, func(i, j int) bool {
+		return dirEntries[i].Name() < dirEntries[j].Name()
+	})
+	return dirEntries, nil
+}
+
+func (w *WebDAVFileSystem) ListFiles(prefix FSName) ([]FSName, error) {
+	return w.ListModifiedSince(prefix, time.Time{})
+}
+
+// Walks the collections one level at a time, since many servers disable infinite depth.
+func (w *WebDAVFileSystem) ListModifiedSince(prefix FSName, since time.Time) ([]FSName, error) {
+	var names []FSName
+	pending := []FSName{prefix}
+	for len(pending) > 0 {
+		dir := pending[0]
+		pending = pending[1:]
+		entries, err := w.propfind(dir, "1")
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.self || strings.HasPrefix(entry.info.Name(), ".") {
+				continue
+			}
+			name := FSName(path.Join(string(dir), entry.info.Name()))
+			if entry.info.IsDir() {
+				pending = append(pending, name)
+			} else if entry.info.ModTime().After(since) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+	return names, nil
+}
+
+func (w *WebDAVFileSystem) TreeHash(prefix FSName) (string, error) {
+	return treeHash(w, prefix)
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   struct {
+		ContentLength int64  `xml:"DAV: getcontentlength"`
+		LastModified  string `xml:"DAV: getlastmodified"`
+		ResourceType  struct {
+			Collection *struct{} `xml:"DAV: collection"`
+		} `xml:"DAV: resourcetype"`
+	} `xml:"DAV: prop"`
+}
+
+type davEntry struct {
+	// the requested resource itself rather than one of its members
+	self bool
+	info *fileInfo
+}
+
+func (w *WebDAVFileSystem) propfind(name FSName, depth string) ([]davEntry, error) {
+	header := http.Header{}
+	header.Set("Depth", depth)
+	header.Set("Content-Type", "application/xml")
+	response, err := w.do("PROPFIND", name, strings.NewReader(davPropfindBody), header)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var multistatus davMultistatus
+	if err := xml.NewDecoder(response.Body).Decode(&multistatus); err != nil {
+		return nil, errors.WithMessage(err, "decode propfind response")
+	}
+	requested := strings.TrimSuffix(w.baseURL.Path+path.Clean("/"+string(name)), "/")
+	var entries []davEntry
+	for _, davResponse := range multistatus.Responses {
+		href, err := url.Parse(davResponse.Href)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "parse href %s", davResponse.Href)
+		}
+		hrefPath := strings.TrimSuffix(href.Path, "/")
+		info := &fileInfo{name: path.Base(hrefPath)}
+		for _, propstat := range davResponse.Propstats {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			info.size = propstat.Prop.ContentLength
+			info.modTime, _ = http.ParseTime(propstat.Prop.LastModified)
+			info.isDir = propstat.Prop.ResourceType.Collection != nil
+		}
+		entries = append(entries, davEntry{self: hrefPath == requested, info: info})
+	}
+	return entries, nil
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// Uploaded parts are staged in a hidden collection per upload until they are joined.
+const webdavMultipartDir = FSName(".multipart")
+
+func webdavUploadDir(uploadID string) FSName {
+	return FSName(path.Join(string(webdavMultipartDir), uploadID))
+}
+
+func webdavPartName(uploadID string, partNumber int) FSName {
+	return FSName(path.Join(string(webdavUploadDir(uploadID)), fmt.Sprint(partNumber)))
+}
+
+// Starts an upload whose parts are stored on the server, see SetFileMultipart. Unlike SetFile,
+// a failed part can be uploaded again without sending the parts before it, which is what makes
+// this worth its cost: completing the upload sends every byte over the network three times and
+// the server holds the parts twice until they are removed. Use SetFile for uploads that are
+// cheap to restart from the beginning.
+func (w *WebDAVFileSystem) CreateMultipartUpload(_ context.Context, _ FSName) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+	if err := w.MkDir(webdavUploadDir(uploadID)); err != nil {
+		return "", errors.WithMessage(err, "create upload collection")
+	}
+	return uploadID, nil
+}
+
+func (w *WebDAVFileSystem) UploadPart(ctx context.Context, _ FSName, uploadID string, partNumber int, part io.ReadSeeker) (string, error) {
+	response, err := w.doContext(ctx, http.MethodPut, webdavPartName(uploadID, partNumber), part, nil)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+	return response.Header.Get("ETag"), nil
+}
+
+// Streams the parts from the staging collection into name, then removes them. WebDAV has no
+// way to join files on the server, so the parts are downloaded and uploaded again. The upload
+// is sent with the summed size of the parts rather than chunked, which some servers reject.
+func (w *WebDAVFileSystem) CompleteMultipartUpload(ctx context.Context, name FSName, uploadID string, parts []CompletedPart) error {
+	size, err := w.uploadSize(uploadID, parts)
+	if err != nil {
+		return errors.WithMessage(err, "get part sizes")
+	}
+	reader, writer := io.Pipe()
+	// unblocks the goroutine below if the upload stops early
+	defer reader.Close()
+	go func() {
+		for _, part := range parts {
+			response, err := w.doContext(ctx, http.MethodGet, webdavPartName(uploadID, part.PartNumber), nil, nil)
+			if err != nil {
+				writer.CloseWithError(errors.WithMessagef(err, "get part %d", part.PartNumber))
+				return
+			}
+			_, err = copyBuffered(writer, response.Body)
+			response.Body.Close()
+			if err != nil {
+				writer.CloseWithError(errors.WithMessagef(err, "copy part %d", part.PartNumber))
+				return
+			}
+		}
+		writer.Close()
+	}()
+	header := http.Header{}
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	if err := w.put(ctx, name, reader, header); err != nil {
+		return err
+	}
+	return w.removeUpload(ctx, uploadID)
+}
+
+// Sums the sizes of the given parts, listed with a single PROPFIND of the staging collection.
+func (w *WebDAVFileSystem) uploadSize(uploadID string, parts []CompletedPart) (int64, error) {
+	entries, err := w.propfind(webdavUploadDir(uploadID), "1")
+	if err != nil {
+		return 0, err
+	}
+	sizes := map[string]int64{}
+	for _, entry := range entries {
+		if !entry.self && !entry.info.IsDir() {
+			sizes[entry.info.Name()] = entry.info.Size()
+		}
+	}
+	var size int64
+	for _, part := range parts {
+		partSize, ok := sizes[fmt.Sprint(part.PartNumber)]
+		if !ok {
+			return 0, errors.WithMessagef(ErrNotFound, "part %d", part.PartNumber)
+		}
+		size += partSize
+	}
+	return size, nil
+}
+
+func (w *WebDAVFileSystem) AbortMultipartUpload(ctx context.Context, _ FSName, uploadID string) error {
+	return w.removeUpload(ctx, uploadID)
+}
+
+func (w *WebDAVFileSystem) removeUpload(ctx context.Context, uploadID string) error {
+	w.collections.Delete(string(webdavUploadDir(uploadID)))
+	response, err := w.doContext(ctx, http.MethodDelete, webdavUploadDir(uploadID), nil, nil)
+	if isNotFound(err) {
+		return nil
+	} else if err != nil {
+		return errors.WithMessage(err, "remove uploaded parts")
+	}
+	response.Body.Close()
+	return nil
+}
@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestWebDAVFileSystem(t *testing.T) *WebDAVFileSystem {
+	server := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	t.Cleanup(server.Close)
+	w, err := NewWebDAVFileSystem(server.URL, "", "")
+	assert.NoError(t, err)
+	return w
+}
+
+func TestWebDAVGetReaderAt(t *testing.T) {
+	w := newTestWebDAVFileSystem(t)
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	for _, name := range []string{"a.txt", "dir/b.txt"} {
+		fileWriter, err := zipWriter.Create(name)
+		assert.NoError(t, err)
+		_, err = fileWriter.Write(bytes.Repeat([]byte(name), 100))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zipWriter.Close())
+	assert.NoError(t, w.SetFile("file.zip", bytes.NewReader(archive.Bytes())))
+
+	reader, size, err := w.GetReaderAt("file.zip", 64, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(archive.Len()), size)
+	zipReader, err := zip.NewReader(reader, size)
+	assert.NoError(t, err)
+	assert.Len(t, zipReader.File, 2)
+	file, err := zipReader.Open("dir/b.txt")
+	assert.NoError(t, err)
+	data, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("dir/b.txt"), 100), data)
+}
+
+func TestWebDAVGetReaderAtInvalid(t *testing.T) {
+	w := newTestWebDAVFileSystem(t)
+	assert.NoError(t, w.SetString("file", "value"))
+	tests := []struct {
+		name      string
+		file      FSName
+		blockSize int64
+		maxBlocks int
+		notFound  bool
+	}{
+		{"missing", "missing", 64, 4, true},
+		{"zero block size", "file", 0, 4, false},
+		{"zero max blocks", "file", 64, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := w.GetReaderAt(test.file, test.blockSize, test.maxBlocks)
+			assert.Error(t, err)
+			assert.Equal(t, test.notFound, isNotFound(err))
+		})
+	}
+}
+
+func TestWebDAVSetGet(t *testing.T) {
+	tests := []struct {
+		name  FSName
+		value string
+	}{
+		{"file", "value"},
+		{"dir/nested/file", "nested"},
+		{"with space & percent%", "escaped"},
+		{"empty", ""},
+	}
+	w := newTestWebDAVFileSystem(t)
+	for _, test := range tests {
+		t.Run(string(test.name), func(t *testing.T) {
+			assert.NoError(t, w.SetString(test.name, test.value))
+			value, err := w.GetString(test.name)
+			assert.NoError(t, err)
+			assert.Equal(t, test.value, value)
+
+			file, err := w.GetFile(test.name)
+			assert.NoError(t, err)
+			data, err := io.ReadAll(file)
+			assert.NoError(t, err)
+			assert.Equal(t, test.value, string(data))
+			stat, err := file.Stat()
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(test.value)), stat.Size())
+			assert.NoError(t, file.Close())
+
+			stat, err = w.Stat(test.name)
+			assert.NoError(t, err)
+			assert.False(t, stat.IsDir())
+			assert.Equal(t, int64(len(test.value)), stat.Size())
+
+			assert.NoError(t, w.RemoveFile(test.name))
+			_, err = w.GetString(test.name)
+			assert.True(t, isNotFound(err))
+		})
+	}
+}
+
+func TestWebDAVNotFound(t *testing.T) {
+	w := newTestWebDAVFileSystem(t)
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"get", func() error {
+			_, err := w.GetBytes("missing")
+			return err
+		}},
+		{"get file", func() error {
+			_, err := w.GetFile("missing")
+			return err
+		}},
+		{"stat", func() error {
+			_, err := w.Stat("missing")
+			return err
+		}},
+		{"read dir", func() error {
+			_, err := w.ReadDir("missing")
+			return err
+		}},
+		{"remove", func() error {
+			return w.RemoveFile("missing")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call()
+			assert.True(t, isNotFound(err), "%v", err)
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+	assert.NoError(t, w.RemoveFileIfExists("missing"))
+}
+
+func TestWebDAVReadDir(t *testing.T) {
+	w := newTestWebDAVFileSystem(t)
+	for _, name := range []FSName{"dir/b", "dir/a", "dir/.hidden", "dir/sub/c", "other"} {
+		assert.NoError(t, w.SetString(name, "value"))
+	}
+	assert.NoError(t, w.MkDir("dir/empty"))
+	// creating an existing collection is a no-op
+	assert.NoError(t, w.MkDir("dir/empty"))
+
+	entries, err := w.ReadDir("dir")
+	assert.NoError(t, err)
+	var names []string
+	var dirs []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+		if entry.IsDir() {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	assert.Equal(t, []string{"a", "b", "empty", "sub"}, names)
+	assert.Equal(t, []string{"empty", "sub"}, dirs)
+
+	stat, err := w.Stat("dir/empty")
+	assert.NoError(t, err)
+	assert.True(t, stat.IsDir())
+}
+
+func TestWebDAVListFiles(t *testing.T) {
+	w := newTestWebDAVFileSystem(t)
+	for _, name := range []FSName{"dir/b", "dir/a", "dir/.hidden", "dir/.hidden-dir/d", "dir/sub/c", "other"} {
+		assert.NoError(t, w.SetString(name, "value"))
+	}
+	tests := []struct {
+		prefix FSName
+		want   []FSName
+	}{
+		{"", []FSName{"dir/a", "dir/b", "dir/sub/c", "other"}},
+		{"dir", []FSName{"dir/a", "dir/b", "dir/sub/c"}},
+		{"dir/sub", []FSName{"dir/sub/c"}},
+	}
+	for _, test := range tests {
+		t.Run(string(test.prefix), func(t *testing.T) {
+			names, err := w.ListFiles(test.prefix)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, names)
+		})
+	}
+
+	// the modification times reported by the server have second precision
+	names, err := w.ListModifiedSince("", time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+	names, err = w.ListModifiedSince("dir", time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []FSName{"dir/a", "dir/b", "dir/sub/c"}, names)
+}
+
+func TestWebDAVWriteTo(t *testing.T) {
+	w := newTestWebDAVFileSystem(t)
+	assert.NoError(t, w.SetString("file", "0123456789"))
+	tests := []struct {
+		name   string
+		offset int64
+		length int64
+		want   string
+	}{
+		{"all", 0, -1, "0123456789"},
+		{"head", 0, 4, "0123"},
+		{"middle", 3, 4, "3456"},
+		{"tail", 6, -1, "6789"},
+		{"empty", 5, 0, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			written, err := w.WriteTo("file", &buffer, test.offset, test.length)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(test.want)), written)
+			assert.Equal(t, test.want, buffer.String())
+		})
+	}
+}
+
+func TestWebDAVBasicAuth(t *testing.T) {
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if username, password, ok := request.BasicAuth(); !ok || username != "user" || password != "secret" {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(writer, request)
+	}))
+	t.Cleanup(server.Close)
+
+	w, err := NewWebDAVFileSystem(server.URL+"/", "user", "secret")
+	assert.NoError(t, err)
+	assert.NoError(t, w.SetString("file", "value"))
+	value, err := w.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	w, err = NewWebDAVFileSystem(server.URL, "user", "wrong")
+	assert.NoError(t, err)
+	_, err = w.GetString("file")
+	assert.Error(t, err)
+	assert.False(t, isNotFound(err))
+}
+
+func TestWebDAVCreatesCollectionsOnce(t *testing.T) {
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	var mu sync.Mutex
+	var mkcols []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "MKCOL" {
+			mu.Lock()
+			mkcols = append(mkcols, request.URL.Path)
+			mu.Unlock()
+		}
+		handler.ServeHTTP(writer, request)
+	}))
+	t.Cleanup(server.Close)
+	w, err := NewWebDAVFileSystem(server.URL, "", "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, w.SetString("a/b/first", "value"))
+	assert.NoError(t, w.SetString("a/b/second", "value"))
+	assert.NoError(t, w.SetString("a/third", "value"))
+	assert.Equal(t, []string{"/a", "/a/b"}, mkcols)
+
+	// a collection removed by another client is created again after the failed write
+	other, err := NewWebDAVFileSystem(server.URL, "", "")
+	assert.NoError(t, err)
+	assert.NoError(t, other.RemoveFile("a"))
+	assert.Error(t, w.SetString("a/b/first", "value"))
+	assert.NoError(t, w.SetString("a/b/first", "value"))
+	value, err := w.GetString("a/b/first")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	assert.Equal(t, []string{"/a", "/a/b", "/a", "/a/b"}, mkcols)
+}
+
+func TestWebDAVClient(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		<-unblock
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(unblock) })
+
+	w, err := NewWebDAVFileSystem(server.URL, "", "", WithWebDAVClient(&http.Client{Timeout: 50 * time.Millisecond}))
+	assert.NoError(t, err)
+	_, err = w.GetString("file")
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "Timeout"), err)
+
+	// the default client only limits how long the server takes to respond
+	w, err = NewWebDAVFileSystem(server.URL, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, webdavResponseTimeout, w.client.Transport.(*http.Transport).ResponseHeaderTimeout)
+	assert.Zero(t, w.client.Timeout)
+}
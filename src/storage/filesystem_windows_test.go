@@ -0,0 +1,22 @@
+//go:build windows
+
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestReplaceRetriesSharingViolation(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	attempts := 0
+	fs.replaceFile = func(string, string) error {
+		attempts++
+		if attempts < 3 {
+			return errorSharingViolation
+		}
+		return nil
+	}
+	assert.NoError(t, fs.SetString("file", "value"))
+	assert.Equal(t, 3, attempts)
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Backoff between attempts to replace a target that is held open, see isSharingViolation.
+var replaceRetryDelays = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+}
+
+// Controls how a fully written temp file is committed over its target.
+type WriteStrategy int
+
+const (
+	// Renames the temp file over the target. Atomic, but needs rename-over-existing support.
+	WriteReplace WriteStrategy = iota
+	// Moves the target aside, then renames the temp file, moving the target back if that
+	// fails. The file is briefly missing.
+	WriteRemoveRename
+	// Copies the temp file into the target in place. Readers may briefly see partial content.
+	WriteDirect
+)
+
+// Sets the write strategy. With autoFallback, a write that fails because renames are
+// unsupported is retried with the next, weaker strategy. Cross-device renames go straight to
+// WriteDirect, since WriteRemoveRename cannot make them work.
+func WithWriteStrategy(strategy WriteStrategy, autoFallback bool) FileSystemOption {
+	return func(a *FileSystemBase) {
+		a.writeStrategy = strategy
+		a.writeFallback = autoFallback
+	}
+}
+
+// Must be called with the write lock held.
+func (a *FileSystemBase) commitTempFile(tempPath string, targetPath string) error {
+	strategy := a.writeStrategy
+	if a.isCopiedTempFile(tempPath) {
+		strategy = WriteDirect
+	}
+	for {
+		err := a.commitTempFileWith(strategy, tempPath, targetPath)
+		if err == nil || !a.writeFallback || strategy == WriteDirect || !isRenameUnsupported(err) {
+			return err
+		}
+		strategy = nextWriteStrategy(strategy, err)
+		a.warn(err, "falling back to weaker write strategy")
+	}
+}
+
+func (a *FileSystemBase) commitTempFileWith(strategy WriteStrategy, tempPath string, targetPath string) error {
+	switch strategy {
+	case WriteReplace:
+		replaceFile := a.replaceFile
+		if replaceFile == nil {
+			replaceFile = atomic.ReplaceFile
+		}
+		err := replaceFile(tempPath, targetPath)
+		// readers usually close the target soon, e.g. when a download finishes
+		for _, delay := range replaceRetryDelays {
+			if err == nil || !isSharingViolation(err) {
+				break
+			}
+			time.Sleep(delay)
+			err = replaceFile(tempPath, targetPath)
+		}
+		return err
+	case WriteRemoveRename:
+		return a.removeRenameFile(tempPath, targetPath)
+	case WriteDirect:
+		return copyFileInPlace(tempPath, targetPath, a.copyBufferSizeOrDefault())
+	default:
+		return errors.Errorf("unknown write strategy %d", strategy)
+	}
+}
+
+// Whether a commit may copy into the target instead of replacing it, see GetMappedFile.
+func (a *FileSystemBase) mayWriteInPlace() bool {
+	return a.writeStrategy == WriteDirect || a.writeFallback || a.tempDirCopy
+}
+
+func nextWriteStrategy(strategy WriteStrategy, err error) WriteStrategy {
+	if errors.Is(err, syscall.EXDEV) {
+		return WriteDirect
+	}
+	return strategy + 1
+}
+
+func (a *FileSystemBase) renameOrDefault() func(source string, target string) error {
+	if a.rename == nil {
+		return os.Rename
+	}
+	return a.rename
+}
+
+// The target is kept under a hidden name until the temp file is in place, so a failed rename
+// never loses the committed file.
+func (a *FileSystemBase) removeRenameFile(tempPath string, targetPath string) error {
+	rename := a.renameOrDefault()
+	backupPath := filepath.Join(filepath.Dir(targetPath), filepath.Base(tempPath)+".old")
+	if err := rename(targetPath, backupPath); os.IsNotExist(err) {
+		return rename(tempPath, targetPath)
+	} else if err != nil {
+		return errors.WithMessage(err, "move target aside")
+	}
+	if err := rename(tempPath, targetPath); err != nil {
+		if restoreErr := rename(backupPath, targetPath); restoreErr != nil {
+			return errors.WithMessagef(err, "restore target: %v", restoreErr)
+		}
+		return err
+	}
+	if err := os.Remove(backupPath); err != nil {
+		// hidden like a temp file, so Compact removes it eventually
+		a.warn(err, "remove replaced file")
+	}
+	return nil
+}
+
+func copyFileInPlace(sourcePath string, targetPath string, bufferSize int) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	if _, err := copyBufferedSize(target, source, bufferSize); err != nil {
+		return errors.WithMessage(err, "copy file")
+	}
+	if err := target.Sync(); err != nil {
+		return errors.WithMessage(err, "sync changes")
+	}
+	return target.Close()
+}
+
+func isRenameUnsupported(err error) bool {
+	return errors.Is(err, syscall.EXDEV) ||
+		errors.Is(err, syscall.ENOTSUP) ||
+		errors.Is(err, syscall.EOPNOTSUPP) ||
+		errors.Is(err, syscall.ENOSYS)
+}
@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How strictly a cached file is checked against the origin before it is served.
+type CacheValidation int
+
+const (
+	// Serves cached files as they are, for origins that are only written through the cache.
+	CacheValidateNone CacheValidation = iota
+	// Compares the size with the origin and requires the cached copy to be at least as new.
+	CacheValidateStat
+	// Compares the SHA-256 digest with the origin. The origin digest comes from its checksum
+	// sidecar if it is a ChecksumFileSystem, otherwise the origin file is read in full.
+	CacheValidateChecksum
+)
+
+// Serves reads from a fast local cache, backed durably by an origin. Writes go to the origin
+// first and then populate the cache. Reads that miss the cache, or whose cached copy no longer
+// matches the origin, fetch the file from the origin into the cache. Once the cache holds more
+// than maxBytes, the least recently used files are evicted from it.
+type WriteThroughCacheFileSystem struct {
+	cache      FileSystem
+	origin     FileSystem
+	validation CacheValidation
+	maxBytes   int64
+
+	mu         sync.Mutex
+	usedBytes  int64
+	lru        *list.List
+	lruEntries map[FSName]*list.Element
+}
+
+type cacheEntry struct {
+	name FSName
+	size int64
+}
+
+// Files already in the cache are picked up, ordered by their mod time.
+func NewWriteThroughCacheFileSystem(cache FileSystem, origin FileSystem, validation CacheValidation, maxBytes int64) (*WriteThroughCacheFileSystem, error) {
+	w := &WriteThroughCacheFileSystem{
+		cache:      cache,
+		origin:     origin,
+		validation: validation,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		lruEntries: map[FSName]*list.Element{},
+	}
+	names, err := cache.ListFiles("")
+	if err != nil {
+		return nil, errors.WithMessage(err, "list cache")
+	}
+	stats := map[FSName]os.FileInfo{}
+	for _, name := range names {
+		stat, err := cache.Stat(name)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "stat %s", name)
+		}
+		stats[name] = stat
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return stats[names[i]].ModTime().Before(stats[names[j]].ModTime())
+	})
+	for _, name := range names {
+		w.track(name, stats[name].Size())
+	}
+	return w, nil
+}
+
+// Marks name as the most recently used entry.
+func (w *WriteThroughCacheFileSystem) track(name FSName, size int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if element, ok := w.lruEntries[name]; ok {
+		entry := element.Value.(*cacheEntry)
+		w.usedBytes += size - entry.size
+		entry.size = size
+		w.lru.MoveToFront(element)
+		return
+	}
+	w.lruEntries[name] = w.lru.PushFront(&cacheEntry{name: name, size: size})
+	w.usedBytes += size
+}
+
+func (w *WriteThroughCacheFileSystem) untrack(name FSName) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if element, ok := w.lruEntries[name]; ok {
+		w.usedBytes -= element.Value.(*cacheEntry).size
+		w.lru.Remove(element)
+		delete(w.lruEntries, name)
+	}
+}
+
+func (w *WriteThroughCacheFileSystem) evict() error {
+	for {
+		w.mu.Lock()
+		back := w.lru.Back()
+		// the most recent entry is never evicted, so a file larger than the cache can still be served
+		if w.usedBytes <= w.maxBytes || back == nil || back == w.lru.Front() {
+			w.mu.Unlock()
+			return nil
+		}
+		name := back.Value.(*cacheEntry).name
+		w.mu.Unlock()
+		if err := w.cache.RemoveFileIfExists(name); err != nil {
+			return errors.WithMessagef(err, "evict %s", name)
+		}
+		w.untrack(name)
+	}
+}
+
+// Copies name from the origin into the cache.
+func (w *WriteThroughCacheFileSystem) populate(name FSName) error {
+	file, err := w.origin.GetFile(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if dir := path.Dir(string(name)); dir != "." {
+		if err := w.cache.MkDir(FSName(dir)); err != nil {
+			return errors.WithMessage(err, "make cache dir")
+		}
+	}
+	if err := w.cache.SetFile(name, file); err != nil {
+		return errors.WithMessage(err, "populate cache")
+	}
+	stat, err := w.cache.Stat(name)
+	if err != nil {
+		return errors.WithMessage(err, "stat cache")
+	}
+	w.track(name, stat.Size())
+	return w.evict()
+}
+
+// Makes sure the cache holds a valid copy of name.
+func (w *WriteThroughCacheFileSystem) fetch(name FSName) error {
+	valid, err := w.isValid(name)
+	if err != nil {
+		return err
+	}
+	if valid {
+		return nil
+	}
+	return w.populate(name)
+}
+
+func (w *WriteThroughCacheFileSystem) isValid(name FSName) (bool, error) {
+	cached, err := w.cache.Stat(name)
+	if isNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.WithMessage(err, "stat cache")
+	}
+	switch w.validation {
+	case CacheValidateNone:
+	case CacheValidateStat:
+		origin, err := w.origin.Stat(name)
+		if err != nil {
+			return false, err
+		}
+		if origin.Size() != cached.Size() || origin.ModTime().After(cached.ModTime()) {
+			return false, nil
+		}
+	case CacheValidateChecksum:
+		originDigest, err := fileChecksum(w.origin, name)
+		if err != nil {
+			return false, err
+		}
+		cachedDigest, err := hashFromFileSystem(w.cache, name, sha256.New())
+		if err != nil {
+			return false, errors.WithMessage(err, "hash cache")
+		}
+		if originDigest != cachedDigest {
+			return false, nil
+		}
+	default:
+		return false, errors.Errorf("unknown cache validation %d", w.validation)
+	}
+	w.track(name, cached.Size())
+	return true, nil
+}
+
+// Runs fn against the cache once it holds a valid copy of name. If the copy is evicted
+// before fn opens it, fn runs against the origin instead.
+func (w *WriteThroughCacheFileSystem) read(name FSName, fn func(FileSystem) error) error {
+	if err := w.fetch(name); err != nil {
+		return err
+	}
+	err := fn(w.cache)
+	if isNotFound(err) {
+		return fn(w.origin)
+	}
+	return err
+}
+
+func (w *WriteThroughCacheFileSystem) GetString(name FSName) (string, error) {
+	var value string
+	err := w.read(name, func(fs FileSystem) (err error) {
+		value, err = fs.GetString(name)
+		return err
+	})
+	return value, err
+}
+
+func (w *WriteThroughCacheFileSystem) GetBytes(name FSName) ([]byte, error) {
+	var data []byte
+	err := w.read(name, func(fs FileSystem) (err error) {
+		data, err = fs.GetBytes(name)
+		return err
+	})
+	return data, err
+}
+
+func (w *WriteThroughCacheFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	var file ReadonlyFile
+	err := w.read(name, func(fs FileSystem) (err error) {
+		file, err = fs.GetFile(name)
+		return err
+	})
+	return file, err
+}
+
+func (w *WriteThroughCacheFileSystem) GetSeekableFile(name FSName) (io.ReadSeekCloser, int64, error) {
+	var file io.ReadSeekCloser
+	var size int64
+	err := w.read(name, func(fs FileSystem) (err error) {
+		file, size, err = fs.GetSeekableFile(name)
+		return err
+	})
+	return file, size, err
+}
+
+// Opens the file like GetSeekableFile, so an evicted copy is served from the origin.
+func (w *WriteThroughCacheFileSystem) WriteTo(name FSName, writer io.Writer, offset int64, length int64) (int64, error) {
+	return writeFileTo(w, name, writer, offset, length)
+}
+
+func (w *WriteThroughCacheFileSystem) StreamArchive(prefix FSName, format ArchiveFormat, writer io.Writer) error {
+	return streamArchive(w, prefix, format, writer)
+}
+
+func (w *WriteThroughCacheFileSystem) SetString(name FSName, value string) error {
+	return w.SetFile(name, strings.NewReader(strings.TrimSpace(value)))
+}
+
+func (w *WriteThroughCacheFileSystem) SetFile(name FSName, value io.Reader) error {
+	if err := w.origin.SetFile(name, value); err != nil {
+		return err
+	}
+	// value is consumed, so copy back from the origin
+	return w.populate(name)
+}
+
+func (w *WriteThroughCacheFileSystem) RemoveFile(name FSName) error {
+	if err := w.origin.RemoveFile(name); err != nil {
+		return err
+	}
+	if err := w.cache.RemoveFileIfExists(name); err != nil {
+		return errors.WithMessage(err, "remove from cache")
+	}
+	w.untrack(name)
+	return nil
+}
+
+func (w *WriteThroughCacheFileSystem) RemoveFileIfExists(name FSName) error {
+	return removeFileIfExists(w, name)
+}
+
+// The origin is authoritative for metadata and listings, the cache may only hold some files.
+func (w *WriteThroughCacheFileSystem) Stat(name FSName) (os.FileInfo, error) {
+	return w.origin.Stat(name)
+}
+
+func (w *WriteThroughCacheFileSystem) MkDir(name FSName) error {
+	if err := w.origin.MkDir(name); err != nil {
+		return err
+	}
+	return w.cache.MkDir(name)
+}
+
+func (w *WriteThroughCacheFileSystem) ReadDir(name FSName) ([]os.DirEntry, error) {
+	return w.origin.ReadDir(name)
+}
+
+func (w *WriteThroughCacheFileSystem) ListFiles(prefix FSName) ([]FSName, error) {
+	return w.origin.ListFiles(prefix)
+}
+
+func (w *WriteThroughCacheFileSystem) ListModifiedSince(prefix FSName, since time.Time) ([]FSName, error) {
+	return w.origin.ListModifiedSince(prefix, since)
+}
+
+func (w *WriteThroughCacheFileSystem) TreeHash(prefix FSName) (string, error) {
+	return w.origin.TreeHash(prefix)
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newTestWriteThroughCache(t *testing.T, validation CacheValidation, maxBytes int64) (*WriteThroughCacheFileSystem, *FileSystemBase, *FileSystemBase) {
+	cache := NewFileSystemBase(t.TempDir())
+	origin := NewFileSystemBase(t.TempDir())
+	w, err := NewWriteThroughCacheFileSystem(cache, origin, validation, maxBytes)
+	assert.NoError(t, err)
+	return w, cache, origin
+}
+
+func TestWriteThroughCacheMiss(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       FSName
+		validation CacheValidation
+	}{
+		{"flat", "file", CacheValidateNone},
+		{"nested", "dir/sub/file", CacheValidateNone},
+		{"stat", "dir/file", CacheValidateStat},
+		{"checksum", "dir/file", CacheValidateChecksum},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, cache, origin := newTestWriteThroughCache(t, test.validation, 1<<20)
+			assert.NoError(t, origin.MkDir(FSName("dir/sub")))
+			assert.NoError(t, origin.SetString(test.file, "value"))
+			value, err := w.GetString(test.file)
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+			cached, err := cache.GetString(test.file)
+			assert.NoError(t, err)
+			assert.Equal(t, "value", cached)
+		})
+	}
+}
+
+func TestWriteThroughCacheStaleCopy(t *testing.T) {
+	w, _, origin := newTestWriteThroughCache(t, CacheValidateChecksum, 1<<20)
+	assert.NoError(t, w.SetString("file", "old"))
+	assert.NoError(t, origin.SetString("file", "new"))
+	value, err := w.GetString("file")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", value)
+}
+
+func TestWriteThroughCacheEviction(t *testing.T) {
+	w, cache, _ := newTestWriteThroughCache(t, CacheValidateNone, 10)
+	for _, name := range []FSName{"a", "b", "c"} {
+		assert.NoError(t, w.SetString(name, "12345"))
+	}
+	exists, err := cache.Exists("a")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	for _, name := range []FSName{"a", "b", "c"} {
+		value, err := w.GetString(name)
+		assert.NoError(t, err)
+		assert.Equal(t, "12345", value)
+	}
+	assert.LessOrEqual(t, w.usedBytes, int64(10))
+}
+
+// Removes its files right before they are opened, like a concurrent eviction would.
+type evictingFileSystem struct {
+	FileSystem
+}
+
+func (e *evictingFileSystem) GetFile(name FSName) (ReadonlyFile, error) {
+	if err := e.FileSystem.RemoveFileIfExists(name); err != nil {
+		return nil, err
+	}
+	return e.FileSystem.GetFile(name)
+}
+
+func TestWriteThroughCacheEvictedBeforeRead(t *testing.T) {
+	cache := &evictingFileSystem{NewFileSystemBase(t.TempDir())}
+	origin := NewFileSystemBase(t.TempDir())
+	w, err := NewWriteThroughCacheFileSystem(cache, origin, CacheValidateNone, 1<<20)
+	assert.NoError(t, err)
+	assert.NoError(t, w.SetString("file", "value"))
+	file, err := w.GetFile("file")
+	assert.NoError(t, err)
+	defer file.Close()
+	stat, err := file.Stat()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, stat.Size())
+}
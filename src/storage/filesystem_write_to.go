@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"io"
+)
+
+// Implements FileSystem.WriteTo on top of GetSeekableFile.
+func writeFileTo(fs FileSystem, name FSName, w io.Writer, offset int64, length int64) (int64, error) {
+	file, _, err := fs.GetSeekableFile(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return copyRange(w, file, offset, length, defaultCopyBufferSize)
+}
+
+// Copies with a pooled buffer of bufferSize bytes. Fails with io.EOF if the file ends before
+// length bytes were copied.
+func copyRange(w io.Writer, r io.ReadSeeker, offset int64, length int64, bufferSize int) (int64, error) {
+	if offset > 0 {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+	if length < 0 {
+		return copyBufferedSize(w, r, bufferSize)
+	}
+	return copyBufferedNSize(w, r, length, bufferSize)
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"archive/zip"
+	"github.com/pkg/errors"
+	"io"
+)
+
+var ErrInvalidZip = errors.New("invalid zip")
+
+// Checks that name is a well-formed zip, such as an IPA, so a truncated or corrupt upload
+// is caught when it is stored rather than deep inside the signing pipeline. The central
+// directory must parse and the local header of every entry must be readable.
+func (a *FileSystemBase) ValidateZip(name FSName) (err error) {
+	defer a.trace("validate zip", name)(&err)
+	file, err := a.GetFile(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return validateZip(file, stat.Size())
+}
+
+func validateZip(reader io.ReaderAt, size int64) error {
+	zipReader, err := zip.NewReader(reader, size)
+	if err != nil {
+		return errors.WithMessagef(ErrInvalidZip, "read central directory: %v", err)
+	}
+	for _, entry := range zipReader.File {
+		// opening an entry reads and checks its local header
+		entryReader, err := entry.Open()
+		if err != nil {
+			return errors.WithMessagef(ErrInvalidZip, "open %s: %v", entry.Name, err)
+		}
+		entryReader.Close()
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// Exposes a FileSystem as an fs.FS, e.g. for http.FS, template.ParseFS or fs.WalkDir.
+// The returned value also implements fs.ReadDirFS and fs.StatFS.
+func AsFS(fileSystem FileSystem) fs.FS {
+	return &fsAdapter{fileSystem: fileSystem}
+}
+
+type fsAdapter struct {
+	fileSystem FileSystem
+}
+
+func toFSName(op string, name string) (FSName, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return "", nil
+	}
+	return FSName(name), nil
+}
+
+// Translates the not found errors of the backends, e.g. ErrNotFound, to fs.ErrNotExist.
+func toPathError(op string, name string, err error) error {
+	if isNotFound(err) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (a *fsAdapter) Open(name string) (fs.File, error) {
+	fsName, err := toFSName("open", name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := a.fileSystem.Stat(fsName)
+	if err != nil {
+		return nil, toPathError("open", name, err)
+	}
+	if stat.IsDir() {
+		entries, err := a.fileSystem.ReadDir(fsName)
+		if err != nil {
+			return nil, toPathError("open", name, err)
+		}
+		return &fsAdapterDir{stat: stat, entries: entries}, nil
+	}
+	file, err := a.fileSystem.GetFile(fsName)
+	if err != nil {
+		return nil, toPathError("open", name, err)
+	}
+	return file, nil
+}
+
+func (a *fsAdapter) ReadDir(name string) ([]fs.DirEntry, error) {
+	fsName, err := toFSName("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := a.fileSystem.ReadDir(fsName)
+	if err != nil {
+		return nil, toPathError("readdir", name, err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (a *fsAdapter) Stat(name string) (fs.FileInfo, error) {
+	fsName, err := toFSName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := a.fileSystem.Stat(fsName)
+	if err != nil {
+		return nil, toPathError("stat", name, err)
+	}
+	return stat, nil
+}
+
+// An opened directory, implementing fs.ReadDirFile.
+type fsAdapterDir struct {
+	stat    os.FileInfo
+	entries []os.DirEntry
+	offset  int
+}
+
+func (d *fsAdapterDir) Stat() (fs.FileInfo, error) {
+	return d.stat, nil
+}
+
+func (d *fsAdapterDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.stat.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *fsAdapterDir) Close() error {
+	return nil
+}
+
+func (d *fsAdapterDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestAdaptedFS(t *testing.T) fs.FS {
+	return adaptTestFileSystem(t, NewFileSystemBase(t.TempDir()))
+}
+
+func adaptTestFileSystem(t *testing.T, fileSystem FileSystem) fs.FS {
+	assert.NoError(t, fileSystem.MkDir("dir/sub"))
+	assert.NoError(t, fileSystem.SetString("file", "value"))
+	assert.NoError(t, fileSystem.SetString("dir/nested", "nested"))
+	assert.NoError(t, fileSystem.SetString("dir/sub/deep", "deep"))
+	return AsFS(fileSystem)
+}
+
+func TestAsFS(t *testing.T) {
+	assert.NoError(t, fstest.TestFS(newTestAdaptedFS(t), "file", "dir/nested", "dir/sub/deep"))
+}
+
+func TestAsFSErrors(t *testing.T) {
+	backends := []struct {
+		name string
+		new  func(t *testing.T) fs.FS
+	}{
+		{"base", newTestAdaptedFS},
+		// fails with ErrNotFound rather than an os error
+		{"webdav", func(t *testing.T) fs.FS {
+			return adaptTestFileSystem(t, newTestWebDAVFileSystem(t))
+		}},
+	}
+	tests := []struct {
+		name string
+		path string
+		err  error
+	}{
+		{"missing", "missing", fs.ErrNotExist},
+		{"missing dir", "missing/file", fs.ErrNotExist},
+		{"parent", "../file", fs.ErrInvalid},
+		{"absolute", "/file", fs.ErrInvalid},
+		{"trailing slash", "dir/", fs.ErrInvalid},
+	}
+	for _, backend := range backends {
+		fsys := backend.new(t)
+		for _, test := range tests {
+			t.Run(backend.name+"/"+test.name, func(t *testing.T) {
+				_, err := fsys.Open(test.path)
+				assert.ErrorIs(t, err, test.err)
+				_, err = fs.Stat(fsys, test.path)
+				assert.ErrorIs(t, err, test.err)
+				_, err = fs.ReadDir(fsys, test.path)
+				assert.ErrorIs(t, err, test.err)
+			})
+		}
+	}
+}
+
+func TestAsFSReadDirFile(t *testing.T) {
+	fsys := newTestAdaptedFS(t)
+	dir, err := fsys.Open("dir")
+	assert.NoError(t, err)
+	defer dir.Close()
+	readDir := dir.(fs.ReadDirFile)
+	entries, err := readDir.ReadDir(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "nested", entries[0].Name())
+	entries, err = readDir.ReadDir(5)
+	assert.NoError(t, err)
+	assert.Equal(t, "sub", entries[0].Name())
+	_, err = readDir.ReadDir(1)
+	assert.ErrorIs(t, err, io.EOF)
+	_, err = dir.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// A readers-writer lock whose acquisition can be abandoned when a context is done.
+// Waiting writers block new readers, so writers are not starved. The zero value is unlocked.
+type contextRWMutex struct {
+	mu             sync.Mutex
+	readers        int
+	writer         bool
+	waitingWriters int
+	// closed and replaced on every release, to wake up waiters
+	released chan struct{}
+}
+
+func (m *contextRWMutex) Lock() {
+	_ = m.LockContext(context.Background())
+}
+
+func (m *contextRWMutex) RLock() {
+	_ = m.RLockContext(context.Background())
+}
+
+func (m *contextRWMutex) LockContext(ctx context.Context) error {
+	m.mu.Lock()
+	m.waitingWriters++
+	for m.writer || m.readers > 0 {
+		released := m.releasedLocked()
+		m.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			m.mu.Lock()
+			m.waitingWriters--
+			// readers may have been blocked by this writer
+			m.signalLocked()
+			m.mu.Unlock()
+			return ctx.Err()
+		}
+		m.mu.Lock()
+	}
+	m.waitingWriters--
+	m.writer = true
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *contextRWMutex) RLockContext(ctx context.Context) error {
+	m.mu.Lock()
+	for m.writer || m.waitingWriters > 0 {
+		released := m.releasedLocked()
+		m.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		m.mu.Lock()
+	}
+	m.readers++
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *contextRWMutex) Unlock() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.writer {
+		panic("unlock of unlocked contextRWMutex")
+	}
+	m.writer = false
+	m.signalLocked()
+}
+
+func (m *contextRWMutex) RUnlock() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readers <= 0 {
+		panic("runlock of unlocked contextRWMutex")
+	}
+	m.readers--
+	if m.readers == 0 {
+		m.signalLocked()
+	}
+}
+
+func (m *contextRWMutex) releasedLocked() chan struct{} {
+	if m.released == nil {
+		m.released = make(chan struct{})
+	}
+	return m.released
+}
+
+func (m *contextRWMutex) signalLocked() {
+	if m.released != nil {
+		close(m.released)
+		m.released = nil
+	}
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"sync"
+)
+
+// Mutexes per key, created on demand and dropped once unused, so locking one name or key
+// never blocks the others. The zero value is ready to use.
+type nameLocks struct {
+	mu    sync.Mutex
+	locks map[string]*nameLock
+}
+
+type nameLock struct {
+	mu   sync.RWMutex
+	refs int
+}
+
+// Blocks until key is locked and returns the func that unlocks it.
+func (l *nameLocks) lock(key string) func() {
+	lock := l.acquire(key)
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		l.release(key, lock)
+	}
+}
+
+// Like lock, but other holders of the shared lock are not blocked.
+func (l *nameLocks) rlock(key string) func() {
+	lock := l.acquire(key)
+	lock.mu.RLock()
+	return func() {
+		lock.mu.RUnlock()
+		l.release(key, lock)
+	}
+}
+
+func (l *nameLocks) acquire(key string) *nameLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locks == nil {
+		l.locks = map[string]*nameLock{}
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &nameLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+func (l *nameLocks) release(key string, lock *nameLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lock.refs--; lock.refs == 0 {
+		delete(l.locks, key)
+	}
+}
@@ -0,0 +1,13 @@
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+const fallocKeepSize = 0x1
+
+// Reserves disk space without changing the file size.
+func preallocate(f *os.File, size int64) error {
+	return syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package storage
+
+import (
+	"os"
+)
+
+// Extends the file to size. The caller truncates it back if fewer bytes are written.
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
+	"time"
 )
 
 type MissingData struct {
@@ -113,6 +114,18 @@ func (p *envProfile) ReadDir(name FSName) ([]os.DirEntry, error) {
 	return nil, errors.New("unsupported operation")
 }
 
+func (p *envProfile) ListFiles(prefix FSName) ([]FSName, error) {
+	return nil, errors.New("unsupported operation")
+}
+
+func (p *envProfile) ListModifiedSince(prefix FSName, since time.Time) ([]FSName, error) {
+	return nil, errors.New("unsupported operation")
+}
+
+func (p *envProfile) TreeHash(prefix FSName) (string, error) {
+	return "", errors.New("unsupported operation")
+}
+
 func (p *envProfile) Stat(name FSName) (os.FileInfo, error) {
 	return nil, errors.New("unsupported operation")
 }
@@ -126,10 +139,26 @@ func (p *envProfile) GetString(name FSName) (string, error) {
 	}
 }
 
+func (p *envProfile) GetBytes(name FSName) ([]byte, error) {
+	return nil, errors.New("unsupported operation")
+}
+
 func (p *envProfile) GetFile(name FSName) (ReadonlyFile, error) {
 	return nil, errors.New("unsupported operation")
 }
 
+func (p *envProfile) GetSeekableFile(name FSName) (io.ReadSeekCloser, int64, error) {
+	return nil, 0, errors.New("unsupported operation")
+}
+
+func (p *envProfile) WriteTo(name FSName, w io.Writer, offset int64, length int64) (int64, error) {
+	return 0, errors.New("unsupported operation")
+}
+
+func (p *envProfile) StreamArchive(prefix FSName, format ArchiveFormat, w io.Writer) error {
+	return errors.New("unsupported operation")
+}
+
 func (p *envProfile) SetString(name FSName, s string) error {
 	return errors.New("unsupported operation")
 }
@@ -142,6 +171,10 @@ func (p *envProfile) RemoveFile(name FSName) error {
 	return errors.New("unsupported operation")
 }
 
+func (p *envProfile) RemoveFileIfExists(name FSName) error {
+	return errors.New("unsupported operation")
+}
+
 func newEnvProfileProv(id string, cfg *config.EnvProfile, certBytes []byte, provBytes []byte) *envProfile {
 	return &envProfile{
 		id:           id,
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Adapts an in-memory io.ReadSeeker to a ReadonlyFile. ReadAt is emulated by seeking
+// unless the reader already implements io.ReaderAt.
+func newReaderFile(name string, reader io.ReadSeeker, modTime time.Time) *readerFile {
+	return &readerFile{ReadSeeker: reader, name: name, modTime: modTime}
+}
+
+type readerFile struct {
+	io.ReadSeeker
+	mu      sync.Mutex
+	name    string
+	modTime time.Time
+}
+
+func (f *readerFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ReadSeeker.Read(p)
+}
+
+func (f *readerFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ReadSeeker.Seek(offset, whence)
+}
+
+func (f *readerFile) ReadAt(p []byte, off int64) (int, error) {
+	if readerAt, ok := f.ReadSeeker.(io.ReaderAt); ok {
+		return readerAt.ReadAt(p, off)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current, err := f.ReadSeeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	defer f.ReadSeeker.Seek(current, io.SeekStart)
+	if _, err := f.ReadSeeker.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f.ReadSeeker, p)
+	// io.ReaderAt reports a read that stops at the end with io.EOF
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *readerFile) Close() error {
+	if closer, ok := f.ReadSeeker.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (f *readerFile) Stat() (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current, err := f.ReadSeeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	size, err := f.ReadSeeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.ReadSeeker.Seek(current, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: f.name, size: size, modTime: f.modTime}, nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (i *fileInfo) Name() string {
+	return i.name
+}
+
+func (i *fileInfo) Size() int64 {
+	return i.size
+}
+
+func (i *fileInfo) Mode() os.FileMode {
+	if i.isDir {
+		return os.ModeDir | 0500
+	}
+	return 0400
+}
+
+func (i *fileInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i *fileInfo) IsDir() bool {
+	return i.isDir
+}
+
+func (i *fileInfo) Sys() any {
+	return nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Hides ReadAt of the wrapped reader, so readerFile has to emulate it.
+type seekOnlyReader struct {
+	io.ReadSeeker
+}
+
+func TestReaderFileReadAt(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int64
+		length int
+		want   string
+		err    error
+	}{
+		{"within", 1, 3, "123", nil},
+		{"whole", 0, 10, "0123456789", nil},
+		{"past end", 8, 4, "89", io.EOF},
+		{"at end", 10, 1, "", io.EOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, reader := range []io.ReadSeeker{
+				strings.NewReader("0123456789"),
+				seekOnlyReader{strings.NewReader("0123456789")},
+			} {
+				file := newReaderFile("file", reader, time.Time{})
+				p := make([]byte, test.length)
+				n, err := file.ReadAt(p, test.offset)
+				assert.Equal(t, test.err, err)
+				assert.Equal(t, test.want, string(p[:n]))
+			}
+		})
+	}
+}
+
+func TestReaderFileReadAtKeepsPosition(t *testing.T) {
+	file := newReaderFile("file", seekOnlyReader{strings.NewReader("0123456789")}, time.Time{})
+	p := make([]byte, 2)
+	_, err := file.Read(p)
+	assert.NoError(t, err)
+	_, err = file.ReadAt(p, 6)
+	assert.NoError(t, err)
+	assert.Equal(t, "67", string(p))
+	rest, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "23456789", string(rest))
+}
+
+func TestGetStringOrDefault(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, fs.SetString("file", "value"))
+	tests := []struct {
+		name string
+		file FSName
+		want string
+	}{
+		{"exists", "file", "value"},
+		{"missing", "missing", "default"},
+		{"missing dir", "dir/missing", "default"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := fs.GetStringOrDefault(test.file, "default")
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, value)
+		})
+	}
+}
+
+func TestGetFileOrReader(t *testing.T) {
+	fs := NewFileSystemBase(t.TempDir())
+	assert.NoError(t, fs.SetString("file", "value"))
+	tests := []struct {
+		name string
+		file FSName
+		want string
+	}{
+		{"exists", "file", "value"},
+		{"missing", "dir/missing", "default"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := fs.GetFileOrReader(test.file, bytes.NewReader([]byte("default")))
+			assert.NoError(t, err)
+			defer file.Close()
+			data, err := io.ReadAll(file)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, string(data))
+			stat, err := file.Stat()
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(test.want)), stat.Size())
+			assert.Equal(t, path.Base(string(test.file)), stat.Name())
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+var ErrResumeFailed = errors.New("cannot resume read")
+
+// How many times a resilient reader reopens its source in a row without making progress.
+const resilientReadRetries = 3
+
+// Opens the source at offset. Returns ErrResumeFailed, possibly wrapped, if it cannot be
+// resumed there, e.g. because the content changed.
+type resumeOpener func(offset int64) (io.ReadCloser, error)
+
+// Reads from a source that is reopened at the current offset whenever a read fails with a
+// transient network error, so the consumer sees one uninterrupted stream.
+type resilientReader struct {
+	open    resumeOpener
+	reader  io.ReadCloser
+	offset  int64
+	retries int
+}
+
+func newResilientReader(open resumeOpener) (*resilientReader, error) {
+	reader, err := open(0)
+	if err != nil {
+		return nil, err
+	}
+	return &resilientReader{open: open, reader: reader}, nil
+}
+
+func (r *resilientReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.reader.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			r.retries = 0
+		}
+		if err == nil || err == io.EOF || !isTransientNetworkError(err) {
+			return n, err
+		}
+		if n > 0 {
+			// deliver what was read, the error comes back on the next read
+			return n, nil
+		}
+		if r.retries++; r.retries > resilientReadRetries {
+			return 0, errors.WithMessage(err, "retries exhausted")
+		}
+		r.reader.Close()
+		reader, openErr := r.open(r.offset)
+		if openErr != nil {
+			r.reader = io.NopCloser(errReader{openErr})
+			return 0, errors.WithMessagef(openErr, "resume at %d", r.offset)
+		}
+		r.reader = reader
+	}
+}
+
+func (r *resilientReader) Close() error {
+	return r.reader.Close()
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// Connection resets and timeouts usually go away when the request is made again.
+func isTransientNetworkError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		isTransientIOError(err)
+}
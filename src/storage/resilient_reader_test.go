@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errDropped = errors.New("connection dropped")
+
+// Sends at most remaining body bytes, then closes the connection, like a reset mid-body.
+type droppingWriter struct {
+	http.ResponseWriter
+	remaining int64
+}
+
+func (w *droppingWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= w.remaining {
+		w.remaining -= int64(len(p))
+		return w.ResponseWriter.Write(p)
+	}
+	n, _ := w.ResponseWriter.Write(p[:w.remaining])
+	w.ResponseWriter.(http.Flusher).Flush()
+	conn, _, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+	return n, errDropped
+}
+
+type droppingServer struct {
+	mu sync.Mutex
+	// body bytes sent by each request before dropping it, -1 or missing to send everything
+	drops []int64
+	// the ETag of each request, the last one applies to all later requests
+	etags   []string
+	content []byte
+	modTime time.Time
+	ranges  []string
+}
+
+func (s *droppingServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	s.mu.Lock()
+	i := len(s.ranges)
+	s.ranges = append(s.ranges, request.Header.Get("Range"))
+	drop := int64(-1)
+	if i < len(s.drops) {
+		drop = s.drops[i]
+	}
+	etag := ""
+	if len(s.etags) > 0 {
+		etag = s.etags[len(s.etags)-1]
+		if i < len(s.etags) {
+			etag = s.etags[i]
+		}
+	}
+	s.mu.Unlock()
+	if etag != "" {
+		writer.Header().Set("ETag", etag)
+	}
+	if drop >= 0 {
+		writer = &droppingWriter{ResponseWriter: writer, remaining: drop}
+	}
+	http.ServeContent(writer, request, "file", s.modTime, bytes.NewReader(s.content))
+}
+
+func TestGetFileResilient(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	tests := []struct {
+		name    string
+		drops   []int64
+		etags   []string
+		modTime time.Time
+		ranges  []string
+		wantErr error
+	}{
+		{"no reset", nil, []string{`"v1"`}, time.Time{}, []string{""}, nil},
+		{"reset mid-body", []int64{30000}, []string{`"v1"`}, time.Time{},
+			[]string{"", "bytes=30000-"}, nil},
+		{"reset twice", []int64{30000, 20000}, []string{`"v1"`}, time.Time{},
+			[]string{"", "bytes=30000-", "bytes=50000-"}, nil},
+		{"reset before body", []int64{0}, []string{`"v1"`}, time.Time{},
+			[]string{"", ""}, nil},
+		{"last-modified validator", []int64{30000}, nil, time.Unix(1600000000, 0),
+			[]string{"", "bytes=30000-"}, nil},
+		{"changed", []int64{30000}, []string{`"v1"`, `"v2"`}, time.Time{},
+			[]string{"", "bytes=30000-"}, ErrResumeFailed},
+		{"no validator", []int64{30000}, nil, time.Time{},
+			[]string{"", "bytes=30000-"}, ErrResumeFailed},
+		{"retries exhausted", []int64{30000, 0, 0, 0}, []string{`"v1"`}, time.Time{},
+			[]string{"", "bytes=30000-", "bytes=30000-", "bytes=30000-"}, io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &droppingServer{drops: test.drops, etags: test.etags, content: content, modTime: test.modTime}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			w, err := NewWebDAVFileSystem(httpServer.URL, "", "")
+			assert.NoError(t, err)
+
+			reader, err := w.GetFileResilient("file")
+			assert.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			assert.NoError(t, reader.Close())
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, content, data)
+			}
+			assert.Equal(t, test.ranges, server.ranges)
+		})
+	}
+}
+
+func TestGetFileResilientNotFound(t *testing.T) {
+	w := newTestWebDAVFileSystem(t)
+	_, err := w.GetFileResilient("missing")
+	assert.True(t, isNotFound(err))
+}
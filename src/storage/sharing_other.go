@@ -0,0 +1,8 @@
+//go:build !windows
+
+package storage
+
+// Open files never block replacing them outside of Windows.
+func isSharingViolation(err error) bool {
+	return false
+}
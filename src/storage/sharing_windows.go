@@ -0,0 +1,17 @@
+//go:build windows
+
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"syscall"
+)
+
+const errorSharingViolation = syscall.Errno(32)
+
+// Replacing a file fails while another handle has it open without FILE_SHARE_DELETE,
+// which includes files opened with os.Open, e.g. by a GetFile reader.
+func isSharingViolation(err error) bool {
+	var errno syscall.Errno
+	return errors.As(err, &errno) && (errno == errorSharingViolation || errno == syscall.ERROR_ACCESS_DENIED)
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+)
+
+// Returns a SHA-256 digest over the names relative to prefix and the contents of all files
+// under it, folded in sorted order. Stores with the same logical content produce the same
+// digest, regardless of how they lay out files on disk.
+func treeHash(fs FileSystem, prefix FSName) (string, error) {
+	names, err := fs.ListFiles(prefix)
+	if err != nil {
+		return "", errors.WithMessage(err, "list files")
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+	tree := sha256.New()
+	for _, name := range names {
+		digest, err := hashFromFileSystem(fs, name, sha256.New())
+		if err != nil {
+			return "", errors.WithMessagef(err, "hash %s", name)
+		}
+		relName := strings.TrimPrefix(strings.TrimPrefix(string(name), string(prefix)), "/")
+		fmt.Fprintf(tree, "%s\x00%s\n", relName, digest)
+	}
+	return hex.EncodeToString(tree.Sum(nil)), nil
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestTreeHashLayouts(t *testing.T) {
+	files := map[FSName]string{
+		"CON":          "reserved",
+		"100%":         "percent",
+		"dir/a:b":      "colon",
+		"dir/sub/file": "nested",
+	}
+	long := FSName("dir/" + strings.Repeat("com.example:", 30))
+	layouts := []struct {
+		name   string
+		prefix FSName
+		long   bool
+		opts   []FileSystemOption
+	}{
+		{"plain", "", false, nil},
+		{"under prefix", "nested/prefix", false, nil},
+		{"sanitized", "", false, []FileSystemOption{WithNameSanitizer()}},
+		{"sanitized long names", "", true, []FileSystemOption{WithNameSanitizer(), WithLongNameHashing()}},
+		{"long names under prefix", "nested", true, []FileSystemOption{WithLongNameHashing()}},
+	}
+	hashes := map[bool]string{}
+	for _, layout := range layouts {
+		t.Run(layout.name, func(t *testing.T) {
+			fs := NewFileSystemBase(t.TempDir(), layout.opts...)
+			write := func(name FSName, value string) {
+				name = FSName(path.Join(string(layout.prefix), string(name)))
+				assert.NoError(t, fs.MkDir(FSName(path.Dir(string(name)))))
+				assert.NoError(t, fs.SetString(name, value))
+			}
+			for name, value := range files {
+				write(name, value)
+			}
+			if layout.long {
+				write(long, "long")
+			}
+			if layout.prefix != "" {
+				// files outside the prefix are not part of its tree, even if their name starts
+				// with it
+				assert.NoError(t, fs.SetString(layout.prefix+"x", "value"))
+			}
+
+			hash, err := fs.TreeHash(layout.prefix)
+			assert.NoError(t, err)
+			if expected, ok := hashes[layout.long]; ok {
+				assert.Equal(t, expected, hash)
+			} else {
+				hashes[layout.long] = hash
+			}
+
+			// content and names are part of the digest
+			write("dir/a:b", "changed")
+			changed, err := fs.TreeHash(layout.prefix)
+			assert.NoError(t, err)
+			assert.NotEqual(t, hash, changed)
+		})
+	}
+	assert.NotEqual(t, hashes[false], hashes[true])
+}
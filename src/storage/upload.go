@@ -15,6 +15,7 @@ type Upload interface {
 	GetId() string
 	delete() error
 	GetData() (ReadonlyFile, error)
+	ValidateData() error
 	GetInfo() (handler.FileInfo, error)
 	GetModTime() (time.Time, error)
 }
@@ -41,6 +42,11 @@ func (u *upload) GetData() (ReadonlyFile, error) {
 	return u.GetFile(FSName(u.id))
 }
 
+// Checks that the uploaded data is a well-formed zip.
+func (u *upload) ValidateData() error {
+	return u.ValidateZip(FSName(u.id))
+}
+
 func (u *upload) GetInfo() (handler.FileInfo, error) {
 	fileName := FSName(u.id + ".info")
 	file, err := u.GetFile(fileName)